@@ -46,9 +46,10 @@ func main() {
 			io.WriteString(w, `<h1>Not Found</h1>`)
 		} else {
 
-			docs := dpsh.LoadAllFiles(basepath, config.SrcPath)
+			docs := dpsh.LoadAllFilesWithConfig(basepath, &config)
 
 			dpsh.PreprocessAllDocs(&config, docs)
+			docs = dpsh.FilterDocs(&config, docs)
 			dpsh.SortDocs(&config, docs)
 
 			w.WriteHeader(http.StatusOK)
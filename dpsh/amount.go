@@ -0,0 +1,212 @@
+package dpsh
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var divNumberFmt string = `<div class="dp-number" data-value="%v">%v</div>`
+var divEstimateFmt string = `<div class="dp-estimate" data-hours="%v">%v</div>`
+var jsonAmountFmt string = `{"number":%v}`
+var jsonAmountWithUnitFmt string = `{"number":%v, "unit":"%v"}`
+var jsonEstimateFmt string = `{"hours":%v}`
+
+// 1日の作業時間の既定値
+const defaultHoursPerDay = 8
+
+// AmountValue decimal型のセクションの値
+type AmountValue struct {
+	Number dptxt.Decimal
+	Unit   string // 「¥」「円」のような通貨記号。なければ空文字列
+}
+
+// SectionAmount セクションのdecimal型の値を返す。decimal型の値でなければnilを返す。
+func SectionAmount(sec *dptxt.Section) *AmountValue {
+	if sec == nil {
+		return nil
+	}
+	av, _ := sec.Data.(*AmountValue)
+	return av
+}
+
+// decimalType decimal型。小数、全角の数字、桁区切り、通貨記号を書ける。sec.Dataには*AmountValueを格納する。
+type decimalType struct {
+	TextType
+}
+
+func (decimalType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil || len(sec.Value) == 0 {
+		return nil
+	}
+	if err := singleValue(ctx, sec); err != nil {
+		return err
+	}
+	d, unit, err := dptxt.ParseAmount(sec.PeekString())
+	if err != nil {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, err)
+	}
+	sec.Data = &AmountValue{d, unit}
+	return nil
+}
+
+func sectionDecimal(sec *dptxt.Section) dptxt.Decimal {
+	if av := SectionAmount(sec); av != nil {
+		return av.Number
+	}
+	return dptxt.Decimal{}
+}
+
+// Compare 値のないものは0として比較する。
+func (decimalType) Compare(a, b *dptxt.Section) int {
+	return sectionDecimal(a).Cmp(sectionDecimal(b))
+}
+
+// Total 値の和を返す。和が範囲を超える場合は空文字列を返す。
+func (decimalType) Total(secs []*dptxt.Section) string {
+	var total dptxt.Decimal
+	var err error
+	for _, sec := range secs {
+		total, err = total.Add(sectionDecimal(sec))
+		if err != nil {
+			log.Println(err)
+			return ""
+		}
+	}
+	return total.String()
+}
+
+func (dtype decimalType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	av := SectionAmount(sec)
+	if av == nil {
+		return dtype.TextType.WriteHTML(config, sec, w)
+	}
+	_, err := w.WriteString(fmt.Sprintf(divNumberFmt, av.Number, html.EscapeString(sec.PeekString())))
+	return err
+}
+
+// WriteCsv 表計算ソフトで集計できるように、桁区切りや通貨記号を付けずに書き出す。
+func (dtype decimalType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if av := SectionAmount(sec); av != nil {
+		_, err := w.WriteString(av.Number.String())
+		return err
+	}
+	return dtype.TextType.WriteCsv(sec, w)
+}
+
+func (dtype decimalType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	av := SectionAmount(sec)
+	if av == nil {
+		return dtype.TextType.WriteJSON(sec, w)
+	}
+	var err error
+	if len(av.Unit) == 0 {
+		_, err = w.WriteString(fmt.Sprintf(jsonAmountFmt, av.Number))
+	} else {
+		_, err = w.WriteString(fmt.Sprintf(jsonAmountWithUnitFmt, av.Number, jsonEscapeString(av.Unit)))
+	}
+	return err
+}
+
+// estimateType estimate型。「3h」「2.5d」「1週間」のような作業量。sec.Dataにはtime.Durationを格納する。
+type estimateType struct {
+	TextType
+}
+
+func (estimateType) ValidateColumn(cd *ColumnConfig) error {
+	if cd.HoursPerDay < 0 || cd.HoursPerDay > 24 {
+		return ErrorInvalidHoursPerDay
+	}
+	return nil
+}
+
+func (estimateType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil || len(sec.Value) == 0 {
+		return nil
+	}
+	if err := singleValue(ctx, sec); err != nil {
+		return err
+	}
+	hours := ctx.Column.HoursPerDay
+	if hours == 0 {
+		hours = defaultHoursPerDay
+	}
+	d, err := dptxt.ParseDuration(sec.PeekString(), time.Duration(hours*float64(time.Hour)))
+	if err != nil {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, err)
+	}
+	sec.Data = d
+	return nil
+}
+
+func sectionDuration(sec *dptxt.Section) time.Duration {
+	if sec != nil {
+		if d, ok := sec.Data.(time.Duration); ok {
+			return d
+		}
+	}
+	return 0
+}
+
+// formatHours 時間数を小数点以下2桁までの文字列にする。
+func formatHours(d time.Duration) string {
+	return strconv.FormatFloat(math.Round(d.Hours()*100)/100, 'f', -1, 64)
+}
+
+// Compare 値のないものは0として比較する。
+func (estimateType) Compare(a, b *dptxt.Section) int {
+	ad := sectionDuration(a)
+	bd := sectionDuration(b)
+	switch {
+	case ad < bd:
+		return -1
+	case ad > bd:
+		return 1
+	}
+	return 0
+}
+
+// Total 作業量の和を時間数で返す。和が範囲を超える場合は空文字列を返す。
+func (estimateType) Total(secs []*dptxt.Section) string {
+	var total time.Duration
+	for _, sec := range secs {
+		d := sectionDuration(sec)
+		if (d > 0 && total > math.MaxInt64-d) || (d < 0 && total < math.MinInt64-d) {
+			log.Println(dptxt.ErrorNumberOutOfRange)
+			return ""
+		}
+		total += d
+	}
+	return formatHours(total)
+}
+
+func (etype estimateType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	if _, ok := sec.Data.(time.Duration); !ok {
+		return etype.TextType.WriteHTML(config, sec, w)
+	}
+	_, err := w.WriteString(fmt.Sprintf(divEstimateFmt, formatHours(sectionDuration(sec)), html.EscapeString(sec.PeekString())))
+	return err
+}
+
+// WriteCsv 表計算ソフトで集計できるように、時間数で書き出す。
+func (etype estimateType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if _, ok := sec.Data.(time.Duration); !ok {
+		return etype.TextType.WriteCsv(sec, w)
+	}
+	_, err := w.WriteString(formatHours(sectionDuration(sec)))
+	return err
+}
+
+func (etype estimateType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	if _, ok := sec.Data.(time.Duration); !ok {
+		return etype.TextType.WriteJSON(sec, w)
+	}
+	_, err := w.WriteString(fmt.Sprintf(jsonEstimateFmt, formatHours(sectionDuration(sec))))
+	return err
+}
@@ -0,0 +1,135 @@
+package dpsh
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+func TestAmountColumns(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "price", "type": "decimal" },
+			{ "name": "work", "type": "estimate", "hoursperday": 7.5 }
+		],
+		"html": { "display": ["price", "work"] }
+	}`)
+	tests := []struct {
+		src  string
+		csv  string
+		html string
+		json string
+	}{
+		{
+			"@price: ¥1,200\n@work: 3h\n",
+			`"1200","3"`,
+			`<div class="dp-c" data-section="price"><div class="dp-number" data-value="1200">¥1,200</div></div>` +
+				`<div class="dp-c" data-section="work"><div class="dp-estimate" data-hours="3">3h</div></div>`,
+			`"price":{"number":1200, "unit":"¥"},"work":{"hours":3}`,
+		},
+		{
+			// 1日は、1週間は5日として、hoursperdayの時間数にする。
+			"@price: -3.50\n@work: 1w\n",
+			`"-3.50","37.5"`,
+			`<div class="dp-c" data-section="price"><div class="dp-number" data-value="-3.50">-3.50</div></div>` +
+				`<div class="dp-c" data-section="work"><div class="dp-estimate" data-hours="37.5">1w</div></div>`,
+			`"price":{"number":-3.50},"work":{"hours":37.5}`,
+		},
+		{
+			"@price: １２円\n@work: 20min\n",
+			`"12","0.33"`,
+			`<div class="dp-c" data-section="price"><div class="dp-number" data-value="12">１２円</div></div>` +
+				`<div class="dp-c" data-section="work"><div class="dp-estimate" data-hours="0.33">20min</div></div>`,
+			`"price":{"number":12, "unit":"円"},"work":{"hours":0.33}`,
+		},
+	}
+	for _, tt := range tests {
+		doc := loadTestDocs(t, config, "a.txt", tt.src)[0]
+		if actual := csvRow(t, config, doc); actual != tt.csv {
+			t.Errorf("%q: %s", tt.src, actual)
+		}
+		html := writeString(t, func(w *bufio.Writer) error {
+			for _, name := range config.HTML.DisplayColumns {
+				if err := htmlWriteSection(config, doc.Lookup(name), name, nil, w); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if html != tt.html {
+			t.Errorf("%q: %s", tt.src, html)
+		}
+		json := writeString(t, func(w *bufio.Writer) error {
+			return jsonWriteDocument(config, doc, w)
+		})
+		if json != `{"filename":"a.txt","sections":{`+tt.json+`} }` {
+			t.Errorf("%q: %s", tt.src, json)
+		}
+	}
+
+	// 解析できない値はそのまま出力する。
+	doc := loadTestDocs(t, config, "a.txt", "@title: t\n@price: 12x\n@work: 3か月\n")[0]
+	if err := doc.Lookup("price").Error; !errors.Is(err, dptxt.ErrorInvalidNumber) {
+		t.Error(err)
+	}
+	if err := doc.Lookup("work").Error; !errors.Is(err, dptxt.ErrorInvalidDuration) {
+		t.Error(err)
+	}
+	if actual := csvRow(t, config, doc); actual != `"12x","3か月"` {
+		t.Error(actual)
+	}
+
+	cd := ColumnConfig{Name: "work", Type: "estimate", HoursPerDay: 25}
+	if err := validateColumnConfig(&cd); err != ErrorInvalidHoursPerDay {
+		t.Error(err)
+	}
+}
+
+func TestColumnTotals(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text" },
+			{ "name": "price", "type": "decimal" },
+			{ "name": "work", "type": "estimate" },
+			{ "name": "count", "type": "number" }
+		],
+		"order": [{ "name": "price" }]
+	}`)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@title: a\n@price: ¥1,200\n@work: 1d\n@count: 2\n",
+		"b.txt", "@title: b\n@price: 0.25\n@work: 30min\n@count: -5\n",
+		"c.txt", "@title: c\n@price: 不明\n",
+	)
+	// 解析できない値と値のないセクションは合計に含めない。
+	totals := config.columnTotals([]string{"title", "price", "work", "count", "none"}, docs)
+	if expected := []string{"", "1200.25", "8.5", "-3", ""}; !reflect.DeepEqual(totals, expected) {
+		t.Errorf("%q", totals)
+	}
+
+	// 値のないものは0として並べる。
+	SortDocs(config, docs)
+	order := ""
+	for _, doc := range docs {
+		order += docBasename(doc)
+	}
+	if order != "cba" {
+		t.Error(order)
+	}
+
+	// 和が範囲を超える場合は合計を出力しない。大きな値の比較は桁をそろえられなくても正しく並べる。
+	docs = loadTestDocs(t, config,
+		"a.txt", "@price: 999,999,999,999,999,999\n@work: 2000000h\n@count: 9223372036854775807\n",
+		"b.txt", "@price: 0.1\n@work: 2000000h\n@count: 1\n",
+	)
+	totals = config.columnTotals([]string{"price", "work", "count"}, docs)
+	if expected := []string{"", "", ""}; !reflect.DeepEqual(totals, expected) {
+		t.Errorf("%q", totals)
+	}
+	SortDocs(config, docs)
+	if docBasename(docs[0]) != "b" {
+		t.Error(docBasename(docs[0]))
+	}
+}
@@ -0,0 +1,65 @@
+package dpsh
+
+import (
+	"strings"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// addComputedColumns computedに定義された計算するカラムを検証し、カラムの定義の最後に加える。
+// 計算するカラムは他のカラムの前処理が終わった後に計算されるので、他のカラムの解析した値を参照できる。
+func addComputedColumns(config *DustpanConfig) error {
+	for _, cd := range config.Computed {
+		if len(cd.Name) == 0 {
+			return ErrorNoColumnName
+		}
+		if len(cd.Expr) == 0 {
+			return ErrorNoExpr
+		}
+		if config.GetColumnDef(cd.Name) != nil {
+			return ErrorDuplicateColumn
+		}
+		if len(cd.Type) == 0 {
+			cd.Type = ColumnTypeText
+		}
+		config.ColumnDefs = append(config.ColumnDefs, cd)
+	}
+	return nil
+}
+
+// compileExpr カラムの定義の式を構文木にしてcdに格納する。
+func compileExpr(cd *ColumnConfig) error {
+	if len(cd.Expr) == 0 {
+		return nil
+	}
+	x, err := parseExpr(cd.Expr)
+	if err != nil {
+		return err
+	}
+	cd.expr = x
+	return nil
+}
+
+// computeSection 式の値からセクションを作り、文書に書かれた同じ名前のセクションを置き換える。
+// 式の値がなければセクションは作らない。
+func computeSection(ctx *PreprocessContext) (*dptxt.Section, error) {
+	cd := ctx.Column
+	delete(ctx.Doc.Sections, cd.Name)
+	v, err := cd.expr.eval(&exprContext{doc: ctx.Doc, now: ctx.Now})
+	if err != nil {
+		return nil, NewValueError(ctx.Doc.Filename, -1, err)
+	}
+	if v.kind == exprNull {
+		return nil, nil
+	}
+	text := v.String()
+	if v.kind == exprTime {
+		text = formatDefaultTime(cd, v.t, v.clock)
+	}
+	sec := dptxt.NewTextSection(text)
+	sec.Value[0].Value = strings.Split(text, "\n")
+	sec.Name = cd.Name
+	sec.Derived = true
+	ctx.Doc.Sections[cd.Name] = sec
+	return sec, nil
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -25,22 +26,61 @@ var sepDq = []byte("\"")
 
 // エラー
 var (
-	ErrorInvalidDate       = errors.New("無効な日付")
-	ErrorNoColumnName      = errors.New("カラム名が未指定")
-	ErrorNoColumnType      = errors.New("カラム型が未指定")
-	ErrorUndefinedColumn   = errors.New("未定義のカラム")
-	ErrorUnknownColumnType = errors.New("未知のカラム型")
-	ErrorMultipleValue     = errors.New("複数の値")
+	ErrorInvalidDate           = errors.New("無効な日付")
+	ErrorNoColumnName          = errors.New("カラム名が未指定")
+	ErrorNoColumnType          = errors.New("カラム型が未指定")
+	ErrorUndefinedColumn       = errors.New("未定義のカラム")
+	ErrorUnknownColumnType     = errors.New("未知のカラム型")
+	ErrorMultipleValue         = errors.New("複数の値")
+	ErrorUnknownDuplicate      = errors.New("未知の重複時の扱い")
+	ErrorInvalidMaxLine        = errors.New("行の長さの上限が負の値")
+	ErrorUnknownDialect        = errors.New("未知のセクション名の書き方")
+	ErrorNoDialectSuffix       = errors.New("セクション名の後ろに置く文字列の指定がない")
+	ErrorNoDialectSrc          = errors.New("セクション名の書き方の対象となるファイルの指定がない")
+	ErrorNoBaseDate            = errors.New("相対的な日付の基準日がない")
+	ErrorNoEnumValues          = errors.New("enum型の値が未定義")
+	ErrorUnknownEnumAlias      = errors.New("enum型の別名が未定義の値を指している")
+	ErrorUnknownEnumValue      = errors.New("許されていない値")
+	ErrorInvalidHoursPerDay    = errors.New("1日の作業時間が範囲外")
+	ErrorNoPersonID            = errors.New("人のidが未指定")
+	ErrorDuplicatePerson       = errors.New("人のid、名前、別名、メールアドレスが重複している")
+	ErrorNoPeople              = errors.New("person型のカラムがあるのに人が未定義")
+	ErrorUnknownPerson         = errors.New("未登録の人")
+	ErrorUnknownRef            = errors.New("存在しない課題への参照")
+	ErrorInvalidBacklinksBase  = errors.New("backlinks型のbaseがref型のカラムではない")
+	ErrorInvalidDependency     = errors.New("dependencyを指定したカラムがref型ではない")
+	ErrorDependencyCycle       = errors.New("依存関係が循環している")
+	ErrorInvalidParagraphCount = errors.New("パラグラフの数の制約が負の値か、最小が最大より大きい")
+	ErrorInvalidBound          = errors.New("最小値か最大値がカラムの型に合わないか、最小が最大より大きい")
+	ErrorRequiredSection       = errors.New("必須のセクションがない")
+	ErrorTooFewParagraphs      = errors.New("パラグラフが少なすぎる")
+	ErrorTooManyParagraphs     = errors.New("パラグラフが多すぎる")
+	ErrorPatternMismatch       = errors.New("値がパターンに一致しない")
+	ErrorBelowMin              = errors.New("値が最小値より小さい")
+	ErrorAboveMax              = errors.New("値が最大値より大きい")
+	ErrorUnknownDefault        = errors.New("未知の既定値の式")
+	ErrorNoExpr                = errors.New("計算するカラムの式が未指定")
+	ErrorDuplicateColumn       = errors.New("カラム名が重複している")
+	ErrorExprSyntax            = errors.New("式の書式に誤りがある")
+	ErrorUnknownFunction       = errors.New("未知の関数")
+	ErrorFunctionArgs          = errors.New("関数の引数の数が合わない")
+	ErrorExprType              = errors.New("式の値の型が合わない")
+	ErrorDivisionByZero        = errors.New("0で割った")
 )
 
 // ValueError 構文エラーを格納する構造体
 type ValueError struct {
 	Filename string
 	Linenum  int
+	Span     dptxt.Span // 誤りのある値の範囲。範囲が分からない場合はゼロ値になる。
 	err      error
 }
 
 func (ve *ValueError) Error() string {
+	if ve.Linenum <= 0 {
+		// 行番号のないエラー(必須のセクションがないなど)
+		return ve.Filename + " " + ve.err.Error()
+	}
 	return ve.Filename + ":" + strconv.FormatInt(int64(ve.Linenum), 10) + " " + ve.err.Error()
 }
 
@@ -57,6 +97,13 @@ func NewValueError(filename string, linenum int, err error) *ValueError {
 	return ve
 }
 
+// NewValueErrorAt 誤りのある値の範囲を指定して構文エラーを生成する。
+func NewValueErrorAt(filename string, linenum int, span dptxt.Span, err error) *ValueError {
+	ve := NewValueError(filename, linenum, err)
+	ve.Span = span
+	return ve
+}
+
 // DustpanConfig 読み込んだ設定ファイルを格納する構造体
 type DustpanConfig struct {
 	SrcPath    []string       `json:"src"`
@@ -64,16 +111,53 @@ type DustpanConfig struct {
 	Csv        CsvConfig      `json:"csv"`
 	ColumnDefs []ColumnConfig `json:"columns"`
 	SortOrder  []SortConfig   `json:"order"`
+	Parser     ParserConfig   `json:"parser"`
+	Filters    []FilterConfig `json:"filter"`
+	People     []PersonConfig `json:"people"`
+	Computed   []ColumnConfig `json:"computed"` // 式で値を計算するカラム
+}
+
+// セクション名が重複した場合の扱いの定義
+const (
+	DuplicateLast  = "last"  // 後のセクションを使う
+	DuplicateMerge = "merge" // パラグラフを結合する
+	DuplicateError = "error" // 文書全体をエラーにする
+)
+
+// ParserConfig 設定ファイルから読み込んだdptxtの読み込み方の設定を格納する構造体
+type ParserConfig struct {
+	Duplicate string          `json:"duplicate"`
+	Comment   []string        `json:"comment"` // 行頭がこれらの文字列で始まる行はコメントになる
+	Lenient   bool            `json:"lenient"` // trueならエラーがあっても読み込めたセクションを出力する
+	MaxLine   int             `json:"maxline"` // 一行のバイト数の上限。0なら制限しない
+	Dialects  []DialectConfig `json:"dialects"`
+}
+
+// DialectConfig 設定ファイルから読み込んだ、ファイルごとのセクション名の行の書き方の設定を格納する構造体
+type DialectConfig struct {
+	Src    []string `json:"src"`    // 対象となるファイルへのパス（ワイルドカード可）
+	Name   string   `json:"name"`   // 定義済みの書き方の名前
+	Prefix []string `json:"prefix"` // nameを省略した場合の、セクション名の前に置く文字列
+	Suffix []string `json:"suffix"` // nameを省略した場合の、セクション名の後ろに置く文字列
 }
 
 // カラムの種別の定義
 const (
-	ColumnTypeText     = "text"
-	ColumnTypeNumber   = "number" // 符号付き整数
-	ColumnTypeDate     = "date"
-	ColumnTypeDeadline = "deadline"
-	ColumnTypeLog      = "log"
-	ColumnTypeFilename = "filename" // 拡張し無しのファイル名
+	ColumnTypeText      = "text"
+	ColumnTypeNumber    = "number" // 符号付き整数
+	ColumnTypeDate      = "date"
+	ColumnTypeDatetime  = "datetime" // 時刻を含めることができる日付
+	ColumnTypeDeadline  = "deadline" // 時刻を含めることができる
+	ColumnTypeLog       = "log"
+	ColumnTypeFilename  = "filename"  // 拡張し無しのファイル名
+	ColumnTypeChecklist = "checklist" // チェックリストの進捗
+	ColumnTypeEnum      = "enum"      // valuesに定義された値のいずれか
+	ColumnTypeTags      = "tags"      // カンマや空白で区切ったタグの集合
+	ColumnTypeDecimal   = "decimal"   // 小数。通貨記号を付けられる
+	ColumnTypeEstimate  = "estimate"  // 「3h」「2.5d」のような作業量
+	ColumnTypePerson    = "person"    // peopleに定義された人
+	ColumnTypeRef       = "ref"       // 他の課題への参照
+	ColumnTypeBacklinks = "backlinks" // その課題を参照している課題
 )
 
 // ColumnConfig 設定ファイルから読み込んだカラムの定義を格納する構造体
@@ -81,12 +165,40 @@ type ColumnConfig struct {
 	Name  string `json:"name"`
 	Type  string `json:"type"`
 	Width string `json:"width"`
+	// deadline型の相対的な日付の基準日のセクション名、またはbacklinks型で参照を集めるref型のセクション名
+	Base string `json:"base"`
+	// enum型で許す値。並べ替えはこの順になる。
+	Values []string `json:"values"`
+	// enum型の値の別名と、それが表わすvaluesの値
+	Aliases map[string]string `json:"aliases"`
+	// estimate型で、1日を何時間とするか。0なら8時間とする。
+	HoursPerDay float64 `json:"hoursperday"`
+	// ref型で、trueなら参照を依存関係とみなして循環を検出する。
+	Dependency bool `json:"dependency"`
+
+	// 以下は値の制約。満たさない値はエラーになる。
+	Required      bool   `json:"required"`      // trueならセクションを省略できない
+	Min           string `json:"min"`           // 最小値。カラムの型で解析して比べる
+	Max           string `json:"max"`           // 最大値。カラムの型で解析して比べる
+	Pattern       string `json:"pattern"`       // それぞれのパラグラフ全体が一致しなければならない正規表現
+	MinParagraphs int    `json:"minparagraphs"` // パラグラフの数の下限。0なら制限しない
+	MaxParagraphs int    `json:"maxparagraphs"` // パラグラフの数の上限。0なら制限しない
+
+	// セクションがないか値が空の場合に使う値。「{today}」のような式も書ける
+	Default string `json:"default"`
+	// 値を計算する式。指定すると文書に書かれた値の代わりに式の値を使う
+	Expr string `json:"expr"`
+
+	pattern  *regexp.Regexp
+	min, max *dptxt.Section
+	expr     exprNode
 }
 
 // CsvConfig 設定ファイルから読み込んだCSV出力の設定を格納する構造体
 type CsvConfig struct {
 	DstPath    string `json:"dst"`
 	AddHeading bool   `json:"heading"`
+	Total      bool   `json:"total"` // trueなら最後に合計の行を出力する
 }
 
 // HTMLConfig 設定ファイルから読み込んだHTML出力の設定を格納する構造体
@@ -97,6 +209,9 @@ type HTMLConfig struct {
 	JsPath         string   `json:"js"`
 	Title          string   `json:"title"`
 	DisplayColumns []string `json:"display"`
+	Era            bool     `json:"era"`   // trueなら日付を元号で表示する
+	Group          string   `json:"group"` // 指定されたカラムのキーごとに文書を分類して表示する
+	Total          bool     `json:"total"` // trueなら最後に合計の行を表示する
 }
 
 // SortConfig 設定ファイルから読み込んだ並べ替えの設定を格納する構造体
@@ -122,14 +237,85 @@ func validateColumnConfig(cc *ColumnConfig) error {
 	if len(cc.Type) == 0 {
 		return ErrorNoColumnType
 	}
-	switch strings.ToLower(cc.Type) {
-	case ColumnTypeText, ColumnTypeNumber, ColumnTypeDate, ColumnTypeDeadline, ColumnTypeLog, ColumnTypeFilename:
+	vt, ok := LookupType(cc.Type)
+	if !ok {
+		return ErrorUnknownColumnType
+	}
+	if v, ok := vt.(ColumnValidator); ok {
+		return v.ValidateColumn(cc)
+	}
+	return nil
+}
+
+func validateParserConfig(pc *ParserConfig) error {
+	if pc.MaxLine < 0 {
+		return ErrorInvalidMaxLine
+	}
+	for _, dc := range pc.Dialects {
+		if len(dc.Src) == 0 {
+			return ErrorNoDialectSrc
+		}
+		if _, err := dc.Dialect(); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(pc.Duplicate) {
+	case "", DuplicateLast, DuplicateMerge, DuplicateError:
 		return nil
 	default:
-		return ErrorUnknownColumnType
+		return ErrorUnknownDuplicate
 	}
 }
 
+// ParseOptions 設定からdptxtのパーサのオプションを生成する。
+func (config *DustpanConfig) ParseOptions() *dptxt.ParseOptions {
+	opts := new(dptxt.ParseOptions)
+	opts.CommentPrefixes = config.Parser.Comment
+	opts.Lenient = config.Parser.Lenient
+	opts.MaxLineLength = config.Parser.MaxLine
+	switch strings.ToLower(config.Parser.Duplicate) {
+	case DuplicateMerge:
+		opts.Duplicate = dptxt.DuplicateMerge
+	case DuplicateError:
+		opts.Duplicate = dptxt.DuplicateError
+	default:
+		opts.Duplicate = dptxt.DuplicateLast
+	}
+	return opts
+}
+
+// Dialect 設定からセクション名の行の書き方を生成する。
+func (dc *DialectConfig) Dialect() (dptxt.Dialect, error) {
+	if len(dc.Name) > 0 {
+		d, ok := dptxt.LookupDialect(dc.Name)
+		if !ok {
+			return d, ErrorUnknownDialect
+		}
+		return d, nil
+	}
+	for _, s := range dc.Suffix {
+		if len(s) > 0 {
+			return dptxt.Dialect{Prefixes: dc.Prefix, Suffixes: dc.Suffix}, nil
+		}
+	}
+	return dptxt.Dialect{}, ErrorNoDialectSuffix
+}
+
+// ParseOptionsFor filenameを読み込むためのdptxtのパーサのオプションを生成する。
+// filenameがparser.dialectsのいずれかのsrcに当てはまれば、最初に当てはまった書き方を使う。
+func (config *DustpanConfig) ParseOptionsFor(basepath string, filename string) *dptxt.ParseOptions {
+	opts := config.ParseOptions()
+	for _, dc := range config.Parser.Dialects {
+		for _, p := range dc.Src {
+			if ok, _ := filepath.Match(normalizePath(basepath, p), filename); ok {
+				opts.Dialect, _ = dc.Dialect()
+				return opts
+			}
+		}
+	}
+	return opts
+}
+
 func validateSortConfig(sc *SortConfig) error {
 	if len(sc.Name) == 0 {
 		return ErrorNoColumnName
@@ -160,10 +346,39 @@ func LoadConfig(filename string, config *DustpanConfig) error {
 		return err
 	}
 
-	for _, cc := range config.ColumnDefs {
-		if err = validateColumnConfig(&cc); err != nil {
+	if err = validateParserConfig(&config.Parser); err != nil {
+		log.Fatal("parser:", err)
+	}
+
+	if err = validatePeople(config.People); err != nil {
+		log.Fatal("people:", err)
+	}
+
+	if err = addComputedColumns(config); err != nil {
+		log.Fatal("computed:", err)
+	}
+
+	for i := range config.ColumnDefs {
+		cc := &config.ColumnDefs[i]
+		if err = validateColumnConfig(cc); err != nil {
+			log.Fatal("columns:", err)
+		}
+		if err = compileSchema(config, cc); err != nil {
 			log.Fatal("columns:", err)
 		}
+		if err = validateDefault(cc); err != nil {
+			log.Fatal("columns:", err)
+		}
+		if err = compileExpr(cc); err != nil {
+			log.Fatal("columns:", cc.Name, ": ", err)
+		}
+		if strings.EqualFold(cc.Type, ColumnTypePerson) && len(config.People) == 0 {
+			log.Fatal("columns:", ErrorNoPeople)
+		}
+	}
+
+	if err = validateRefColumns(config); err != nil {
+		log.Fatal("columns:", err)
 	}
 
 	for _, sc := range config.SortOrder {
@@ -175,6 +390,15 @@ func LoadConfig(filename string, config *DustpanConfig) error {
 			log.Fatal("order:", ErrorUndefinedColumn)
 		}
 	}
+
+	for _, fc := range config.Filters {
+		if config.GetColumnDef(fc.Name) == nil {
+			log.Fatal("filter:", ErrorUndefinedColumn)
+		}
+	}
+	if len(config.HTML.Group) > 0 && config.GetColumnDef(config.HTML.Group) == nil {
+		log.Fatal("html.group:", ErrorUndefinedColumn)
+	}
 	return nil
 }
 
@@ -187,6 +411,20 @@ func normalizePath(basepath string, path string) string {
 
 // LoadAllFiles 対象となるすべてのファイルを読み込む
 func LoadAllFiles(basepath string, paths []string) []*dptxt.Document {
+	return loadAllFiles(basepath, paths, func(string) *dptxt.ParseOptions {
+		return nil
+	})
+}
+
+// LoadAllFilesWithConfig 設定に基づいて対象となるすべてのファイルを読み込む
+func LoadAllFilesWithConfig(basepath string, config *DustpanConfig) []*dptxt.Document {
+	return loadAllFiles(basepath, config.SrcPath, func(filename string) *dptxt.ParseOptions {
+		return config.ParseOptionsFor(basepath, filename)
+	})
+}
+
+// loadAllFiles pathsに一致するすべてのファイルを、それぞれのファイルについてoptsが返すオプションに従って読み込む。
+func loadAllFiles(basepath string, paths []string, opts func(filename string) *dptxt.ParseOptions) []*dptxt.Document {
 	docs := make([]*dptxt.Document, 0)
 	for _, p := range paths {
 		ap := normalizePath(basepath, p)
@@ -196,7 +434,7 @@ func LoadAllFiles(basepath string, paths []string) []*dptxt.Document {
 		} else {
 			for _, g := range gp {
 				var doc *dptxt.Document = new(dptxt.Document)
-				err := LoadFile(g, doc)
+				err := LoadFileWithOptions(g, doc, opts(g))
 				if err != nil {
 					log.Println(g, err)
 				} else {
@@ -210,13 +448,18 @@ func LoadAllFiles(basepath string, paths []string) []*dptxt.Document {
 
 // LoadFile filenameで指定されるファイルを読み込み、docに格納する。
 func LoadFile(filename string, doc *dptxt.Document) error {
+	return LoadFileWithOptions(filename, doc, nil)
+}
+
+// LoadFileWithOptions filenameで指定されるファイルをoptsに従って読み込み、docに格納する。
+func LoadFileWithOptions(filename string, doc *dptxt.Document, opts *dptxt.ParseOptions) error {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 	b = skipUtf8BOM(b)
 	f := bytes.NewReader(b)
-	err = dptxt.ParseDocument(filename, f, doc)
+	err = dptxt.ParseDocumentWithOptions(filename, f, doc, opts)
 	if err != nil {
 		return err
 	}
@@ -234,49 +477,11 @@ func SortDocs(config *DustpanConfig, docs []*dptxt.Document) {
 		cdefs[si] = config.GetColumnDef(sc.Name)
 	}
 
-	var zerotime time.Time
-
 	sort.Slice(docs, func(i, j int) bool {
 		a := docs[i]
 		b := docs[j]
 		for si, c := range config.SortOrder {
-			var r int64
-			as := a.Sections[c.Name]
-			bs := b.Sections[c.Name]
-			cd := cdefs[si]
-			if cd.Type == ColumnTypeText || cd.Type == ColumnTypeLog || cd.Type == ColumnTypeFilename {
-				// 対応するセクションがなければ空文字列として扱う。
-				av := ""
-				bv := ""
-				if as != nil {
-					av = as.PeekString()
-				}
-				if bs != nil {
-					bv = bs.PeekString()
-				}
-				r = int64(strings.Compare(av, bv))
-			} else if cd.Type == ColumnTypeDate || cd.Type == ColumnTypeDeadline {
-				// Timeメンバがnilの場合は現在時刻が入っているものとして扱う。
-				at := &zerotime
-				if as != nil && as.Time != nil {
-					at = as.Time
-				}
-				bt := &zerotime
-				if bs != nil && bs.Time != nil {
-					bt = bs.Time
-				}
-				r = int64(at.Sub(*bt))
-			} else if cd.Type == ColumnTypeNumber {
-				av := int64(0)
-				bv := int64(0)
-				if as != nil {
-					av = as.Number
-				}
-				if bs != nil {
-					bv = bs.Number
-				}
-				r = av - bv
-			}
+			r := cdefs[si].ValueType().Compare(a.Lookup(c.Name), b.Lookup(c.Name))
 			if r != 0 {
 				return (r < 0) != c.Descending
 			}
@@ -285,102 +490,110 @@ func SortDocs(config *DustpanConfig, docs []*dptxt.Document) {
 	})
 }
 
-func preprocessDoc(config *DustpanConfig, now *time.Time, doc *dptxt.Document) {
-	for _, cd := range config.ColumnDefs {
-		c := doc.Sections[cd.Name]
-		if c == nil {
-			if cd.Type == ColumnTypeFilename {
-				base := filepath.Base(doc.Filename)
-				ext := filepath.Ext(doc.Filename)
-				doc.Sections[cd.Name] = dptxt.NewTextSection(strings.TrimSuffix(base, ext))
+// docErrorMessages 文書全体のエラーのメッセージを行番号付きで返す。
+func docErrorMessages(doc *dptxt.Document) []string {
+	if doc.Error == nil {
+		return nil
+	}
+	var pes dptxt.ParseErrors
+	if !errors.As(doc.Error, &pes) {
+		return []string{doc.Error.Error()}
+	}
+	msgs := make([]string, len(pes))
+	for i, pe := range pes {
+		msgs[i] = strconv.Itoa(pe.Line) + "行目 " + pe.Err.Error()
+	}
+	return msgs
+}
+
+// clockText 時刻を表示する文字列にする。秒は0でなければ、時差は明示されていれば付ける。
+func clockText(t *time.Time) string {
+	layout := "15:04"
+	if t.Second() != 0 {
+		layout += ":05"
+	}
+	if t.Location() != time.Local {
+		layout += " -07:00"
+	}
+	return t.Format(layout)
+}
+
+// docBasename 文書のファイル名から拡張子を除いたものを返す。
+func docBasename(doc *dptxt.Document) string {
+	base := filepath.Base(doc.Filename)
+	ext := filepath.Ext(doc.Filename)
+	return strings.TrimSuffix(base, ext)
+}
+
+func preprocessDoc(config *DustpanConfig, now *time.Time, index map[string]*dptxt.Document, doc *dptxt.Document) {
+	// 式で値を計算するカラムは、columnsとcomputedのどちらに定義したものも、他のすべてのカラムの前処理が終わった後で
+	// 定義された順に計算する。
+	for _, computed := range []bool{false, true} {
+		for i := range config.ColumnDefs {
+			if cd := &config.ColumnDefs[i]; (cd.expr != nil) == computed {
+				preprocessColumn(config, now, index, doc, cd)
 			}
-			continue
 		}
-		switch cd.Type {
-		case ColumnTypeNumber:
-			if len(c.Value) == 1 {
-				if len(c.Value[0].Value) > 1 {
-					c.Error = NewValueError(doc.Filename, c.Value[0].Linenum, ErrorMultipleValue)
-				} else {
-					num, err := strconv.ParseInt(c.PeekString(), 10, 64)
-					if err != nil {
-						c.Error = NewValueError(doc.Filename, c.Linenum, err)
-					} else {
-						c.Number = num
-					}
-				}
-			} else if len(c.Value) >= 2 {
-				c.Error = NewValueError(doc.Filename, c.Value[0].Linenum, ErrorMultipleValue)
-			}
-		case ColumnTypeDate, ColumnTypeDeadline:
-			if len(c.Value) == 1 {
-				if len(c.Value[0].Value) > 1 {
-					c.Error = NewValueError(doc.Filename, c.Value[0].Linenum, ErrorMultipleValue)
-				} else {
-					pb := c.PeekString()
-					year, month, day, post, err := dptxt.ParseDate(pb)
-					if err != nil {
-						c.Error = NewValueError(doc.Filename, c.Linenum, err)
-					} else if len(post) > 0 {
-						c.Error = NewValueError(doc.Filename, c.Value[0].Linenum, ErrorMultipleValue)
-					} else {
-						// 日付の妥当性をチェックする。time.Date()を使った結果に対して、日付けが正規化されていないことを確認する。
-						t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
-						if t.Year() == year && t.Month() == time.Month(month) && t.Day() == day {
-							c.Time = new(time.Time)
-							*(c.Time) = t
-							// 有効期限型で値が現在日時より前なら有効期限切れのフラグを立てる。
-							if cd.Type == ColumnTypeDeadline && t.Before(*now) {
-								c.Expired = true
-							}
-						} else {
-							c.Error = NewValueError(doc.Filename, c.Linenum, ErrorInvalidDate)
-						}
-					}
-				}
-			} else if len(c.Value) >= 2 {
-				c.Error = NewValueError(doc.Filename, c.Value[0].Linenum, ErrorMultipleValue)
-			}
-		case ColumnTypeLog:
-			for _, p := range c.Value {
-				if len(p.Value) > 0 {
-					year, month, day, pre, post, err := dptxt.ParseLogDate(p.Value[len(p.Value)-1])
-					if err != nil {
-						p.Error = NewValueError(doc.Filename, p.Linenum+len(p.Value)-1, err)
-					} else {
-						t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
-						if t.Year() == year && t.Month() == time.Month(month) && t.Day() == day {
-							p.Time = new(time.Time)
-							p.Value[len(p.Value)-1] = pre
-							*(p.Time) = t
-							p.TimeSuffix = post
-						} else {
-							p.Error = NewValueError(doc.Filename, p.Linenum+len(p.Value)-1, ErrorInvalidDate)
-						}
-					}
-				}
-				if p.Error != nil {
-					log.Println(p.Error)
-				}
-			}
+	}
+}
+
+// preprocessColumn 文書のcdのカラムのセクションに前処理を施す。
+func preprocessColumn(config *DustpanConfig, now *time.Time, index map[string]*dptxt.Document, doc *dptxt.Document, cd *ColumnConfig) {
+	c := doc.Lookup(cd.Name)
+	ctx := &PreprocessContext{Config: config, Column: cd, Doc: doc, Now: *now, Docs: index}
+	var err error
+	if cd.expr != nil {
+		// 計算するカラムは、文書に書かれた値を式の値で置き換える。
+		c, err = computeSection(ctx)
+	}
+	if err == nil && (c == nil || len(c.Value) == 0) && len(cd.Default) > 0 {
+		if sec := fillDefault(ctx); sec != nil {
+			c = sec
 		}
-		if c.Error != nil {
-			log.Println(c.Error)
+	}
+	if err == nil {
+		err = cd.ValueType().Preprocess(ctx, c)
+	}
+	// 前処理でセクションが作られることがある。
+	c = doc.Lookup(cd.Name)
+	if err == nil && (c == nil || c.Error == nil) {
+		err = checkSchema(ctx, c)
+	}
+	if c == nil {
+		if err == nil {
+			return
 		}
+		// 必須のセクションがない場合や式を計算できない場合は、エラーを表示するための値のないセクションを作る。
+		c = &dptxt.Section{Name: cd.Name, Linenum: -1}
+		doc.Sections[cd.Name] = c
+	}
+	if err != nil {
+		c.Error = err
+	}
+	if c.Error != nil {
+		log.Println(c.Error)
 	}
 }
 
 // PreprocessAllDocs 全ての文書に前処理を施す。
 func PreprocessAllDocs(config *DustpanConfig, docs []*dptxt.Document) {
+	preprocessAllDocsAt(config, docs, time.Now())
+}
+
+// preprocessAllDocsAt nowを前処理を始めた日時として、全ての文書に前処理を施す。
+func preprocessAllDocsAt(config *DustpanConfig, docs []*dptxt.Document, now time.Time) {
 	if config.ColumnDefs == nil || len(config.ColumnDefs) == 0 {
 		return
 	}
 
-	now := time.Now()
-
+	index := make(map[string]*dptxt.Document, len(docs))
+	for _, d := range docs {
+		index[docBasename(d)] = d
+	}
 	for _, d := range docs {
-		preprocessDoc(config, &now, d)
+		preprocessDoc(config, &now, index, d)
 	}
+	linkDocs(config, index, docs)
 }
 
 const tempfileTemplate = "_dustpan_%s.*.tmp"
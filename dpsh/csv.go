@@ -7,15 +7,20 @@ import (
 	"github.com/healthy-tiger/dustpan/dptxt"
 )
 
+// csvEscapeString ダブルクォートで囲んだフィールドの中に入れられるように文字列をエスケープする。
+func csvEscapeString(s string) string {
+	return strings.ReplaceAll(s, `"`, `""`)
+}
+
 func csvWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 	var err error
 	sep := sepEmpty
-	for _, v := range para.Value {
+	for _, v := range para.Inlines() {
 		_, err = w.Write(sep)
 		if err != nil {
 			return err
 		}
-		_, err = w.WriteString(v)
+		_, err = w.WriteString(csvEscapeString(dptxt.PlainText(v)))
 		if err != nil {
 			return err
 		}
@@ -24,23 +29,15 @@ func csvWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 	return nil
 }
 
-func csvWriteSection(sec *dptxt.Section, w *bufio.Writer) error {
+func csvWriteSection(cd *ColumnConfig, sec *dptxt.Section, w *bufio.Writer) error {
 	_, err := w.Write(sepDq)
 	if err != nil {
 		return err
 	}
 	if sec != nil {
-		sep := sepEmpty
-		for _, p := range sec.Value {
-			_, err = w.Write(sep)
-			if err != nil {
-				return err
-			}
-			err = csvWriteParagraph(p, w)
-			if err != nil {
-				return err
-			}
-			sep = sep2Newline
+		err = cd.ValueType().WriteCsv(sec, w)
+		if err != nil {
+			return err
 		}
 	}
 	_, err = w.Write(sepDq)
@@ -53,12 +50,13 @@ func csvWriteSection(sec *dptxt.Section, w *bufio.Writer) error {
 func csvWriteDocument(config *DustpanConfig, doc *dptxt.Document, w *bufio.Writer) error {
 	var err error
 	sep := sepEmpty
-	for _, cd := range config.ColumnDefs {
+	for i := range config.ColumnDefs {
+		cd := &config.ColumnDefs[i]
 		_, err = w.Write(sep)
 		if err != nil {
 			return err
 		}
-		err = csvWriteSection(doc.Sections[cd.Name], w)
+		err = csvWriteSection(cd, doc.Lookup(cd.Name), w)
 		if err != nil {
 			return err
 		}
@@ -87,7 +85,7 @@ func WriteCsv(basepath string, config *DustpanConfig, docs []*dptxt.Document) er
 	cols := make([]string, len(config.ColumnDefs))
 	if config.Csv.AddHeading {
 		for i, cd := range config.ColumnDefs {
-			cols[i] = "\"" + cd.Name + "\""
+			cols[i] = "\"" + csvEscapeString(cd.Name) + "\""
 		}
 		_, err = w.WriteString(strings.Join(cols, ","))
 		if err != nil {
@@ -110,6 +108,24 @@ func WriteCsv(basepath string, config *DustpanConfig, docs []*dptxt.Document) er
 		}
 	}
 
+	if config.Csv.Total {
+		names := make([]string, len(config.ColumnDefs))
+		for i, cd := range config.ColumnDefs {
+			names[i] = cd.Name
+		}
+		for i, t := range config.columnTotals(names, docs) {
+			cols[i] = "\"" + csvEscapeString(t) + "\""
+		}
+		_, err = w.WriteString(strings.Join(cols, ","))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(sepNewline)
+		if err != nil {
+			return err
+		}
+	}
+
 	w.Flush()
 	return nil
 }
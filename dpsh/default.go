@@ -0,0 +1,88 @@
+package dpsh
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// 既定値に書ける式。値を求められなければ、セクションは作らない。
+const (
+	DefaultToday    = "{today}"    // 前処理を始めた日付
+	DefaultNow      = "{now}"      // 前処理を始めた日時
+	DefaultMtime    = "{mtime}"    // ファイルの更新日時
+	DefaultFirstLog = "{firstlog}" // 最初のlog型のカラムの最初のログの日付
+	DefaultLastLog  = "{lastlog}"  // 最初のlog型のカラムの最後のログの日付
+	DefaultFilename = "{filename}" // ファイル名から拡張子を除いたもの
+)
+
+var defaultExprs = []string{DefaultToday, DefaultNow, DefaultMtime, DefaultFirstLog, DefaultLastLog, DefaultFilename}
+
+// isDefaultExpr 既定値が式かどうか
+func isDefaultExpr(v string) bool {
+	return strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}")
+}
+
+func validateDefault(cd *ColumnConfig) error {
+	if !isDefaultExpr(cd.Default) {
+		return nil
+	}
+	for _, e := range defaultExprs {
+		if strings.EqualFold(cd.Default, e) {
+			return nil
+		}
+	}
+	return ErrorUnknownDefault
+}
+
+// formatDefaultTime 日時をカラムの型で読める文字列にする。時刻を書けないdate型とclockがfalseの場合は日付だけにする。
+func formatDefaultTime(cd *ColumnConfig, t time.Time, clock bool) string {
+	if dt, ok := cd.ValueType().(dateType); !clock || (ok && !dt.clock) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// defaultValue 文書にセクションがない場合の値を返す。値を求められなければfalseを返す。
+func defaultValue(ctx *PreprocessContext) (string, bool) {
+	cd := ctx.Column
+	if !isDefaultExpr(cd.Default) {
+		return cd.Default, len(cd.Default) > 0
+	}
+	switch strings.ToLower(cd.Default) {
+	case DefaultToday:
+		return formatDefaultTime(cd, ctx.Now, false), true
+	case DefaultNow:
+		return formatDefaultTime(cd, ctx.Now, true), true
+	case DefaultMtime:
+		fi, err := os.Stat(ctx.Doc.Filename)
+		if err != nil {
+			return "", false
+		}
+		return formatDefaultTime(cd, fi.ModTime(), true), true
+	case DefaultFirstLog, DefaultLastLog:
+		t, ok := logDate(ctx.Config, ctx.Doc, strings.EqualFold(cd.Default, DefaultLastLog))
+		if !ok {
+			return "", false
+		}
+		return formatDefaultTime(cd, t, t.Hour() != 0 || t.Minute() != 0), true
+	case DefaultFilename:
+		return docBasename(ctx.Doc), true
+	}
+	return "", false
+}
+
+// fillDefault 文書にセクションがなければ、既定値から作ったセクションを文書に加えて返す。
+func fillDefault(ctx *PreprocessContext) *dptxt.Section {
+	v, ok := defaultValue(ctx)
+	if !ok {
+		return nil
+	}
+	sec := dptxt.NewTextSection(v)
+	sec.Name = ctx.Column.Name
+	sec.Derived = true
+	ctx.Doc.Sections[ctx.Column.Name] = sec
+	return sec
+}
@@ -0,0 +1,128 @@
+package dpsh
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const defaultTestConfig = `{
+	"columns": [
+		{ "name": "status", "type": "text", "default": "open" },
+		{ "name": "created", "type": "date", "default": "{today}" },
+		{ "name": "checked", "type": "datetime", "default": "{NOW}" },
+		{ "name": "updated", "type": "datetime", "default": "{mtime}" },
+		{ "name": "started", "type": "datetime", "default": "{firstlog}" },
+		{ "name": "last", "type": "date", "default": "{lastlog}" },
+		{ "name": "name", "type": "text", "default": "{filename}" },
+		{ "name": "log", "type": "log" }
+	],
+	"html": { "display": ["status", "created", "log"] }
+}`
+
+func TestDefaultValue(t *testing.T) {
+	f, err := ioutil.TempFile("", "dpsh_test_*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	src := `@log: a(2020/1/2)
+
+b(2020/1/5 13:30)
+`
+	_, err = f.WriteString(src)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2019, 12, 31, 23, 59, 0, 0, time.Local)
+	if err = os.Chtimes(f.Name(), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	config := loadTestConfig(t, defaultTestConfig)
+	doc := loadTestDocs(t, config, f.Name(), src)[0]
+	name := strings.TrimSuffix(filepath.Base(f.Name()), ".txt")
+	tests := []struct {
+		column string
+		value  string
+	}{
+		{"status", "open"},
+		{"created", "2020-01-10"},
+		{"checked", "2020-01-10 09:00"},
+		{"updated", "2019-12-31 23:59"},
+		{"started", "2020-01-02"},
+		{"last", "2020-01-05"},
+		{"name", name},
+	}
+	for _, tt := range tests {
+		sec := doc.Lookup(tt.column)
+		if sec == nil || sec.Error != nil || !sec.Derived || sec.PeekString() != tt.value {
+			t.Errorf("%s: %+v", tt.column, sec)
+		}
+	}
+	// 既定値から作ったセクションもカラムの型で解析する。
+	if dv := SectionDate(doc.Lookup("checked")); dv == nil || !dv.HasClock {
+		t.Error("checked", dv)
+	}
+	if doc.Lookup("log").Derived {
+		t.Error("log")
+	}
+}
+
+func TestDefaultNotFilled(t *testing.T) {
+	config := loadTestConfig(t, defaultTestConfig)
+	// 値が書かれていれば既定値は使わない。ファイルやログがなければ、それらから求める既定値は作らない。
+	doc := loadTestDocs(t, config, "none/a.txt", "@status: closed\n@created: 2020/1/1\n")[0]
+	tests := []struct {
+		column string
+		value  string
+	}{
+		{"status", "closed"},
+		{"created", "2020/1/1"},
+		{"updated", ""},
+		{"started", ""},
+		{"last", ""},
+	}
+	for _, tt := range tests {
+		sec := doc.Lookup(tt.column)
+		if (len(tt.value) == 0 && sec != nil) || (len(tt.value) > 0 && (sec == nil || sec.Derived || sec.PeekString() != tt.value)) {
+			t.Errorf("%s: %+v", tt.column, sec)
+		}
+	}
+
+	// 空のセクションは既定値で置き換える。
+	doc = loadTestDocs(t, config, "a.txt", "@status:\n")[0]
+	if sec := doc.Lookup("status"); !sec.Derived || sec.PeekString() != "open" {
+		t.Error(sec.PeekString())
+	}
+
+	cd := ColumnConfig{Name: "v", Type: "date", Default: "{yesterday}"}
+	if err := validateDefault(&cd); err != ErrorUnknownDefault {
+		t.Error(err)
+	}
+}
+
+func TestDerivedOutput(t *testing.T) {
+	config := loadTestConfig(t, defaultTestConfig)
+	doc := loadTestDocs(t, config, "a.txt", "@created: 2020/1/1\n")[0]
+
+	html := writeString(t, func(w *bufio.Writer) error {
+		return htmlWriteDocument(config, doc, true, w)
+	})
+	if !strings.Contains(html, `<div class="dp-c dp-derived" data-section="status"><div class="dp-p">open</div></div>`) ||
+		!strings.Contains(html, `<div class="dp-c" data-section="created">`) {
+		t.Error(html)
+	}
+
+	json := writeString(t, func(w *bufio.Writer) error {
+		return jsonWriteDocument(config, doc, w)
+	})
+	if !strings.HasSuffix(json, `, "derived":["status"] }`) {
+		t.Error(json)
+	}
+}
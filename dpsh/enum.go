@@ -0,0 +1,140 @@
+package dpsh
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var divEnumFmt string = `<div class="dp-enum dp-enum-%v" data-value="%v">%v</div>`
+
+// EnumValue enum型のセクションの値
+type EnumValue struct {
+	Value string // 別名を置き換えた、valuesに定義された値
+	Index int    // valuesの中での順番
+}
+
+// SectionEnum セクションのenum型の値を返す。enum型の値でなければnilを返す。
+func SectionEnum(sec *dptxt.Section) *EnumValue {
+	if sec == nil {
+		return nil
+	}
+	ev, _ := sec.Data.(*EnumValue)
+	return ev
+}
+
+// enumType enum型。値はvaluesかaliasesに定義されたものだけを許し、valuesの順に並べる。sec.Dataには*EnumValueを格納する。
+type enumType struct {
+	TextType
+}
+
+func (enumType) ValidateColumn(cd *ColumnConfig) error {
+	if len(cd.Values) == 0 {
+		return ErrorNoEnumValues
+	}
+	for _, v := range cd.Aliases {
+		if enumIndex(cd.Values, v) < 0 {
+			return ErrorUnknownEnumAlias
+		}
+	}
+	return nil
+}
+
+// enumIndex valuesの中でvと同じ値の位置を返す。大文字と小文字は区別しない。なければ-1を返す。
+func enumIndex(values []string, v string) int {
+	for i, e := range values {
+		if strings.EqualFold(e, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupEnum 別名を置き換えて、値の位置を返す。
+func lookupEnum(cd *ColumnConfig, v string) int {
+	if i := enumIndex(cd.Values, v); i >= 0 {
+		return i
+	}
+	for a, e := range cd.Aliases {
+		if strings.EqualFold(a, v) {
+			return enumIndex(cd.Values, e)
+		}
+	}
+	return -1
+}
+
+func (enumType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil || len(sec.Value) == 0 {
+		return nil
+	}
+	if err := singleValue(ctx, sec); err != nil {
+		return err
+	}
+	i := lookupEnum(ctx.Column, sec.PeekString())
+	if i < 0 {
+		p := sec.Value[0]
+		return NewValueErrorAt(ctx.Doc.Filename, sec.Linenum, p.SpanOf(0, 0, len(p.Value[0])), ErrorUnknownEnumValue)
+	}
+	sec.Data = &EnumValue{ctx.Column.Values[i], i}
+	return nil
+}
+
+// enumOrder 並べ替えのための順番を返す。値がなければ定義されたどの値よりも後ろにする。
+func enumOrder(sec *dptxt.Section) int {
+	if ev := SectionEnum(sec); ev != nil {
+		return ev.Index
+	}
+	return math.MaxInt32
+}
+
+func (enumType) Compare(a, b *dptxt.Section) int {
+	return enumOrder(a) - enumOrder(b)
+}
+
+func (enumType) Keys(sec *dptxt.Section) []string {
+	if ev := SectionEnum(sec); ev != nil {
+		return []string{ev.Value}
+	}
+	return nil
+}
+
+// cssClassName 値をCSSのクラス名に使える文字列にする。英字は小文字にし、文字と数字以外は「-」にする。
+func cssClassName(v string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, v)
+}
+
+func (etype enumType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	ev := SectionEnum(sec)
+	if ev == nil {
+		return etype.TextType.WriteHTML(config, sec, w)
+	}
+	v := html.EscapeString(ev.Value)
+	_, err := w.WriteString(fmt.Sprintf(divEnumFmt, html.EscapeString(cssClassName(ev.Value)), v, v))
+	return err
+}
+
+func (etype enumType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if ev := SectionEnum(sec); ev != nil {
+		_, err := w.WriteString(csvEscapeString(ev.Value))
+		return err
+	}
+	return etype.TextType.WriteCsv(sec, w)
+}
+
+func (etype enumType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	if ev := SectionEnum(sec); ev != nil {
+		_, err := w.WriteString(`"` + jsonEscapeString(ev.Value) + `"`)
+		return err
+	}
+	return etype.TextType.WriteJSON(sec, w)
+}
@@ -0,0 +1,126 @@
+package dpsh
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestEnum(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text" },
+			{
+				"name": "status",
+				"type": "enum",
+				"values": ["Open", "In Progress", "Closed"],
+				"aliases": { "wip": "in progress", "done": "Closed" }
+			}
+		],
+		"html": { "display": ["status"] }
+	}`)
+	// 値は大文字と小文字を区別せずに比べ、別名はvaluesの値に置き換える。
+	tests := []struct {
+		src   string
+		csv   string
+		index int
+		err   error
+	}{
+		{"@title: a\n@status: Open\n", `"a","Open"`, 0, nil},
+		{"@title: b\n@status: in progress\n", `"b","In Progress"`, 1, nil},
+		{"@title: c\n@status: WIP\n", `"c","In Progress"`, 1, nil},
+		{"@title: d\n@status: done\n", `"d","Closed"`, 2, nil},
+		{"@title: e\n@status: 未着手\n", `"e","未着手"`, -1, ErrorUnknownEnumValue},
+		{"@title: f\n@status: open\n  closed\n", `"f","open` + "\n" + `closed"`, -1, ErrorMultipleValue},
+	}
+	for _, tt := range tests {
+		doc := loadTestDocs(t, config, "a.txt", tt.src)[0]
+		sec := doc.Lookup("status")
+		if !errors.Is(sec.Error, tt.err) || (tt.err == nil && sec.Error != nil) {
+			t.Errorf("%q: %v", tt.src, sec.Error)
+		}
+		if ev := SectionEnum(sec); (ev == nil && tt.index >= 0) || (ev != nil && ev.Index != tt.index) {
+			t.Errorf("%q: %+v", tt.src, ev)
+		}
+		if actual := csvRow(t, config, doc); actual != tt.csv {
+			t.Errorf("%q: %s", tt.src, actual)
+		}
+	}
+
+	// 値の誤りの範囲は値の部分だけにする。
+	doc := loadTestDocs(t, config, "a.txt", "@title: t\n@status: 未着手\n")[0]
+	var ve *ValueError
+	if !errors.As(doc.Lookup("status").Error, &ve) || ve.Linenum != 2 || ve.Span.Start.Column != 10 || ve.Span.End.Column != 13 {
+		t.Error(ve)
+	}
+
+	doc = loadTestDocs(t, config, "a.txt", "@status: wip\n")[0]
+	html := writeString(t, func(w *bufio.Writer) error {
+		return htmlWriteSection(config, doc.Lookup("status"), "status", nil, w)
+	})
+	if html != `<div class="dp-c" data-section="status"><div class="dp-enum dp-enum-in-progress" data-value="In Progress">In Progress</div></div>` {
+		t.Error(html)
+	}
+	actual := writeString(t, func(w *bufio.Writer) error {
+		return jsonWriteDocument(config, doc, w)
+	})
+	if actual != `{"filename":"a.txt","sections":{"status":"In Progress"} }` {
+		t.Error(actual)
+	}
+}
+
+func TestEnumColumnConfig(t *testing.T) {
+	tests := []struct {
+		src string
+		err error
+	}{
+		{`{ "name": "v", "type": "enum", "values": ["a", "b"] }`, nil},
+		{`{ "name": "v", "type": "enum", "values": ["a", "b"], "aliases": { "x": "B" } }`, nil},
+		{`{ "name": "v", "type": "enum" }`, ErrorNoEnumValues},
+		{`{ "name": "v", "type": "enum", "values": ["a"], "aliases": { "x": "c" } }`, ErrorUnknownEnumAlias},
+	}
+	for _, tt := range tests {
+		var cd ColumnConfig
+		if err := json.Unmarshal([]byte(tt.src), &cd); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateColumnConfig(&cd); err != tt.err {
+			t.Errorf("%s: %v", tt.src, err)
+		}
+	}
+}
+
+func TestEnumOrder(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "status", "type": "enum", "values": ["Open", "In Progress", "Closed"], "aliases": { "wip": "In Progress" } }
+		],
+		"order": [{ "name": "status" }]
+	}`)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@status: closed\n",
+		"b.txt", "@title: なし\n",
+		"c.txt", "@status: open\n",
+		"d.txt", "@status: wip\n",
+	)
+	// valuesの順に並べ、値のない文書は最後にする。
+	tests := []struct {
+		descending bool
+		order      string
+	}{
+		{false, "cdab"},
+		{true, "badc"},
+	}
+	for _, tt := range tests {
+		config.SortOrder[0].Descending = tt.descending
+		SortDocs(config, docs)
+		order := ""
+		for _, doc := range docs {
+			order += docBasename(doc)
+		}
+		if order != tt.order {
+			t.Error(tt.descending, order)
+		}
+	}
+}
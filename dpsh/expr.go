@@ -0,0 +1,837 @@
+package dpsh
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// 計算するカラムの式の文法
+//
+//	式       = 論理和
+//	論理和   = 論理積 { "||" 論理積 }
+//	論理積   = 比較 { "&&" 比較 }
+//	比較     = 加減算 [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) 加減算 ]
+//	加減算   = 乗除算 { ( "+" | "-" ) 乗除算 }
+//	乗除算   = 単項 { ( "*" | "/" | "%" ) 単項 }
+//	単項     = ( "-" | "!" ) 単項 | 一次式
+//	一次式   = 数値 | 文字列 | "[" セクション名 "]" | 関数名 "(" [ 式 { "," 式 } ] ")" | "true" | "false" | "null" | "(" 式 ")"
+//
+// 式は文書のセクションの値と現在の日時だけを参照し、繰り返しやファイルの読み書きはできない。
+
+// exprKind 式の値の種類
+type exprKind int
+
+const (
+	exprNull exprKind = iota // 値がない
+	exprNumber
+	exprString
+	exprBool
+	exprTime
+)
+
+// exprValue 式の値
+type exprValue struct {
+	kind  exprKind
+	num   float64
+	str   string
+	b     bool
+	t     time.Time
+	clock bool           // exprTimeで時刻を持つか
+	sec   *dptxt.Section // セクションを参照した値なら、そのセクション
+}
+
+var nullValue = exprValue{kind: exprNull}
+
+func numberValue(n float64) exprValue {
+	return exprValue{kind: exprNumber, num: n}
+}
+
+func stringValue(s string) exprValue {
+	return exprValue{kind: exprString, str: s}
+}
+
+func boolValue(b bool) exprValue {
+	return exprValue{kind: exprBool, b: b}
+}
+
+func timeValue(t time.Time, clock bool) exprValue {
+	return exprValue{kind: exprTime, t: t, clock: clock}
+}
+
+// hasClock 日時が0時0分でなければtrueを返す。
+func hasClock(t time.Time) bool {
+	return t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0
+}
+
+// truthy 条件としての真偽を返す。値がなければ偽、数値は0以外、文字列は空でなければ真とする。
+func (v exprValue) truthy() bool {
+	switch v.kind {
+	case exprNumber:
+		return v.num != 0
+	case exprString:
+		return len(v.str) > 0
+	case exprBool:
+		return v.b
+	case exprTime:
+		return true
+	}
+	return false
+}
+
+// formatNumber 浮動小数点数の誤差が出ないように、小数点以下6桁までで丸めて文字列にする。
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(math.Round(n*1e6)/1e6, 'f', -1, 64)
+}
+
+// String 値を文字列にする。
+func (v exprValue) String() string {
+	switch v.kind {
+	case exprNumber:
+		return formatNumber(v.num)
+	case exprString:
+		return v.str
+	case exprBool:
+		return strconv.FormatBool(v.b)
+	case exprTime:
+		if v.clock {
+			return v.t.Format("2006-01-02 15:04")
+		}
+		return v.t.Format("2006-01-02")
+	}
+	return ""
+}
+
+// sectionExprValue セクションの値を、セクションの型で解析した値に従って式の値にする。
+func sectionExprValue(sec *dptxt.Section) exprValue {
+	if sec == nil {
+		return nullValue
+	}
+	v := nullValue
+	switch d := sec.Data.(type) {
+	case int64:
+		v = numberValue(float64(d))
+	case *AmountValue:
+		v = numberValue(d.Number.Float64())
+	case time.Duration:
+		v = numberValue(d.Hours())
+	case *DateValue:
+		v = timeValue(d.Time, d.HasClock)
+	case *EnumValue:
+		v = stringValue(d.Value)
+	case *dptxt.Checklist:
+		v = numberValue(float64(d.Percent()))
+	case []string:
+		v = stringValue(strings.Join(d, ", "))
+	case []PersonValue:
+		ids := make([]string, len(d))
+		for i, p := range d {
+			ids[i] = p.ID
+		}
+		v = stringValue(strings.Join(ids, ", "))
+	case *RefValue:
+		v = stringValue(strings.Join(d.Targets, ", "))
+	default:
+		// ログは日付を取り除いた値にする。
+		paras := make([]string, 0, len(sec.Value))
+		for _, p := range logParagraphs(sec) {
+			paras = append(paras, strings.Join(p.Value, "\n"))
+		}
+		if len(paras) > 0 && sec.Error == nil {
+			v = stringValue(strings.Join(paras, "\n\n"))
+		}
+	}
+	if sec.Error != nil {
+		// 値に誤りがあれば値がないものとする。
+		v = nullValue
+	}
+	v.sec = sec
+	return v
+}
+
+// exprContext 式を評価するときに参照する情報
+type exprContext struct {
+	doc *dptxt.Document
+	now time.Time
+}
+
+// exprNode 構文木の節
+type exprNode interface {
+	eval(ctx *exprContext) (exprValue, error)
+}
+
+type literalNode struct {
+	value exprValue
+}
+
+func (n *literalNode) eval(ctx *exprContext) (exprValue, error) {
+	return n.value, nil
+}
+
+type sectionNode struct {
+	name string
+}
+
+func (n *sectionNode) eval(ctx *exprContext) (exprValue, error) {
+	return sectionExprValue(ctx.doc.Lookup(n.name)), nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(ctx *exprContext) (exprValue, error) {
+	x, err := n.x.eval(ctx)
+	if err != nil {
+		return nullValue, err
+	}
+	if n.op == "!" {
+		return boolValue(!x.truthy()), nil
+	}
+	switch x.kind {
+	case exprNull:
+		return nullValue, nil
+	case exprNumber:
+		return numberValue(-x.num), nil
+	}
+	return nullValue, ErrorExprType
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+// daysBetween bからaまでの日数を返す。どちらも時刻を持たなければ、暦の上での日数にする。
+func daysBetween(a, b exprValue) float64 {
+	if !a.clock && !b.clock {
+		ay, am, ad := a.t.Date()
+		by, bm, bd := b.t.Date()
+		return time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC).Sub(time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	}
+	return a.t.Sub(b.t).Hours() / 24
+}
+
+// addDays 日時にn日を加える。
+func addDays(v exprValue, n float64) exprValue {
+	if n == math.Trunc(n) {
+		return timeValue(v.t.AddDate(0, 0, int(n)), v.clock)
+	}
+	return timeValue(v.t.Add(time.Duration(n*24*float64(time.Hour))), true)
+}
+
+// compareValues 同じ種類の値を比べる。
+func compareValues(a, b exprValue) (int, error) {
+	if a.kind != b.kind {
+		return 0, ErrorExprType
+	}
+	switch a.kind {
+	case exprNumber:
+		switch {
+		case a.num < b.num:
+			return -1, nil
+		case a.num > b.num:
+			return 1, nil
+		}
+		return 0, nil
+	case exprString:
+		return strings.Compare(a.str, b.str), nil
+	case exprTime:
+		switch {
+		case a.t.Before(b.t):
+			return -1, nil
+		case a.t.After(b.t):
+			return 1, nil
+		}
+		return 0, nil
+	case exprBool:
+		if a.b == b.b {
+			return 0, nil
+		}
+		if b.b {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (n *binaryNode) eval(ctx *exprContext) (exprValue, error) {
+	l, err := n.l.eval(ctx)
+	if err != nil {
+		return nullValue, err
+	}
+	// 論理演算は短絡評価する。
+	switch n.op {
+	case "&&":
+		if !l.truthy() {
+			return boolValue(false), nil
+		}
+		r, err := n.r.eval(ctx)
+		return boolValue(r.truthy()), err
+	case "||":
+		if l.truthy() {
+			return boolValue(true), nil
+		}
+		r, err := n.r.eval(ctx)
+		return boolValue(r.truthy()), err
+	}
+	r, err := n.r.eval(ctx)
+	if err != nil {
+		return nullValue, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq := l.kind == r.kind
+		if eq && l.kind != exprNull {
+			c, _ := compareValues(l, r)
+			eq = c == 0
+		}
+		return boolValue(eq == (n.op == "==")), nil
+	}
+	// 値のないものとの演算は値がないものとする。
+	if l.kind == exprNull || r.kind == exprNull {
+		return nullValue, nil
+	}
+	switch n.op {
+	case "<", "<=", ">", ">=":
+		c, err := compareValues(l, r)
+		if err != nil {
+			return nullValue, err
+		}
+		switch n.op {
+		case "<":
+			return boolValue(c < 0), nil
+		case "<=":
+			return boolValue(c <= 0), nil
+		case ">":
+			return boolValue(c > 0), nil
+		}
+		return boolValue(c >= 0), nil
+	case "+":
+		switch {
+		case l.kind == exprNumber && r.kind == exprNumber:
+			return numberValue(l.num + r.num), nil
+		case l.kind == exprTime && r.kind == exprNumber:
+			return addDays(l, r.num), nil
+		case l.kind == exprNumber && r.kind == exprTime:
+			return addDays(r, l.num), nil
+		case l.kind == exprString || r.kind == exprString:
+			return stringValue(l.String() + r.String()), nil
+		}
+	case "-":
+		switch {
+		case l.kind == exprNumber && r.kind == exprNumber:
+			return numberValue(l.num - r.num), nil
+		case l.kind == exprTime && r.kind == exprNumber:
+			return addDays(l, -r.num), nil
+		case l.kind == exprTime && r.kind == exprTime:
+			return numberValue(daysBetween(l, r)), nil
+		}
+	case "*", "/", "%":
+		if l.kind != exprNumber || r.kind != exprNumber {
+			break
+		}
+		switch n.op {
+		case "*":
+			return numberValue(l.num * r.num), nil
+		case "/":
+			if r.num == 0 {
+				return nullValue, ErrorDivisionByZero
+			}
+			return numberValue(l.num / r.num), nil
+		}
+		if r.num == 0 {
+			return nullValue, ErrorDivisionByZero
+		}
+		return numberValue(math.Mod(l.num, r.num)), nil
+	}
+	return nullValue, ErrorExprType
+}
+
+// exprFunc 式で使える関数
+type exprFunc struct {
+	minArgs int
+	maxArgs int // -1なら制限しない
+	call    func(ctx *exprContext, args []exprNode) (exprValue, error)
+}
+
+type callNode struct {
+	fn   *exprFunc
+	args []exprNode
+}
+
+func (n *callNode) eval(ctx *exprContext) (exprValue, error) {
+	return n.fn.call(ctx, n.args)
+}
+
+// evalArgs すべての引数を評価する。
+func evalArgs(ctx *exprContext, args []exprNode) ([]exprValue, error) {
+	values := make([]exprValue, len(args))
+	for i, a := range args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// numberFunc 数値を一つ取る関数を作る。
+func numberFunc(f func(float64) float64) *exprFunc {
+	return &exprFunc{1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+		v, err := args[0].eval(ctx)
+		if err != nil || v.kind == exprNull {
+			return nullValue, err
+		}
+		if v.kind != exprNumber {
+			return nullValue, ErrorExprType
+		}
+		return numberValue(f(v.num)), nil
+	}}
+}
+
+// extremeFunc 値のないものを除いて、最小または最大の値を返す関数を作る。
+func extremeFunc(sign int) *exprFunc {
+	return &exprFunc{1, -1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+		values, err := evalArgs(ctx, args)
+		if err != nil {
+			return nullValue, err
+		}
+		r := nullValue
+		for _, v := range values {
+			if v.kind == exprNull {
+				continue
+			}
+			if r.kind == exprNull {
+				r = v
+				continue
+			}
+			c, err := compareValues(v, r)
+			if err != nil {
+				return nullValue, err
+			}
+			if c*sign > 0 {
+				r = v
+			}
+		}
+		return r, nil
+	}}
+}
+
+// logFunc 最初または最後のログの日付を返す関数を作る。
+func logFunc(last bool) *exprFunc {
+	return &exprFunc{1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+		v, err := args[0].eval(ctx)
+		if err != nil || v.sec == nil {
+			return nullValue, err
+		}
+		paras := logParagraphs(v.sec)
+		for i := range paras {
+			p := paras[i]
+			if last {
+				p = paras[len(paras)-1-i]
+			}
+			if t, ok := logTime(p); ok {
+				return timeValue(t, hasClock(t)), nil
+			}
+		}
+		return nullValue, nil
+	}}
+}
+
+var exprFuncs map[string]*exprFunc
+
+func init() {
+	exprFuncs = map[string]*exprFunc{
+		// now() dpshを実行した日時
+		"now": {0, 0, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			return timeValue(ctx.now, true), nil
+		}},
+		// today() dpshを実行した日付
+		"today": {0, 0, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			y, m, d := ctx.now.Date()
+			return timeValue(time.Date(y, m, d, 0, 0, 0, 0, ctx.now.Location()), false), nil
+		}},
+		// if(条件, 真のときの値, 偽のときの値)
+		"if": {3, 3, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			c, err := args[0].eval(ctx)
+			if err != nil {
+				return nullValue, err
+			}
+			if c.truthy() {
+				return args[1].eval(ctx)
+			}
+			return args[2].eval(ctx)
+		}},
+		// coalesce(値, ...) 最初の値のあるもの
+		"coalesce": {1, -1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			for _, a := range args {
+				v, err := a.eval(ctx)
+				if err != nil || v.kind != exprNull {
+					return v, err
+				}
+			}
+			return nullValue, nil
+		}},
+		// isempty(値) 値がないか空文字列ならtrue
+		"isempty": {1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			v, err := args[0].eval(ctx)
+			return boolValue(v.kind == exprNull || (v.kind == exprString && len(v.str) == 0)), err
+		}},
+		// count(セクション) タグや人、参照の数、チェックリストの項目の数、それ以外はパラグラフの数
+		"count": {1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			v, err := args[0].eval(ctx)
+			if err != nil || v.sec == nil {
+				return numberValue(0), err
+			}
+			switch d := v.sec.Data.(type) {
+			case []string:
+				return numberValue(float64(len(d))), nil
+			case []PersonValue:
+				return numberValue(float64(len(d))), nil
+			case *RefValue:
+				return numberValue(float64(len(d.Targets))), nil
+			case *dptxt.Checklist:
+				return numberValue(float64(d.Total)), nil
+			}
+			return numberValue(float64(len(v.sec.Value))), nil
+		}},
+		// firstlog(セクション)、lastlog(セクション) 最初と最後のログの日付
+		"firstlog": logFunc(false),
+		"lastlog":  logFunc(true),
+		// date(値) 文字列を日付にする
+		"date": {1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			v, err := args[0].eval(ctx)
+			if err != nil || v.kind == exprNull || v.kind == exprTime {
+				return v, err
+			}
+			if v.kind != exprString {
+				return nullValue, ErrorExprType
+			}
+			dt, post, err := dptxt.ParseDateTime(v.str)
+			if err != nil || len(strings.TrimSpace(post)) > 0 {
+				return nullValue, ErrorExprType
+			}
+			t, ok := dt.Time(time.Local)
+			if !ok {
+				return nullValue, ErrorExprType
+			}
+			return timeValue(t, dt.HasClock), nil
+		}},
+		// number(値) 文字列を数値にする
+		"number": {1, 1, func(ctx *exprContext, args []exprNode) (exprValue, error) {
+			v, err := args[0].eval(ctx)
+			if err != nil || v.kind == exprNull || v.kind == exprNumber {
+				return v, err
+			}
+			if v.kind != exprString {
+				return nullValue, ErrorExprType
+			}
+			d, err := dptxt.ParseDecimal(v.str)
+			if err != nil {
+				return nullValue, ErrorExprType
+			}
+			return numberValue(d.Float64()), nil
+		}},
+		"abs":   numberFunc(math.Abs),
+		"floor": numberFunc(math.Floor),
+		"ceil":  numberFunc(math.Ceil),
+		"round": numberFunc(math.Round),
+		"min":   extremeFunc(-1),
+		"max":   extremeFunc(1),
+	}
+}
+
+// exprToken 式の字句
+type exprToken struct {
+	kind int
+	text string
+	pos  int // 式の先頭からのバイト位置
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokSection
+	tokIdent
+	tokOp
+)
+
+// 2文字の演算子を先に比べる。
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ","}
+
+func exprSyntaxError(pos int) error {
+	return fmt.Errorf("%w(%d文字目)", ErrorExprSyntax, pos+1)
+}
+
+// tokenizeExpr 式を字句に分ける。
+func tokenizeExpr(src string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	i := 0
+	for i < len(src) {
+		r, s := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += s
+		case r >= '0' && r <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokNumber, src[i:j], i})
+			i = j
+		case r == '"':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(src) {
+					return nil, exprSyntaxError(i)
+				}
+				if src[j] == '"' {
+					break
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+				j++
+			}
+			tokens = append(tokens, exprToken{tokString, b.String(), i})
+			i = j + 1
+		case r == '[':
+			j := strings.IndexByte(src[i:], ']')
+			if j < 0 {
+				return nil, exprSyntaxError(i)
+			}
+			// セクション名は文書のセクション名と同じように正規化する。
+			tokens = append(tokens, exprToken{tokSection, dptxt.NormalizeSectionName(src[i+1 : i+j]), i})
+			i += j + 1
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(src) {
+				r, s := utf8.DecodeRuneInString(src[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+					break
+				}
+				j += s
+			}
+			tokens = append(tokens, exprToken{tokIdent, src[i:j], i})
+			i = j
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, exprToken{tokOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, exprSyntaxError(i)
+			}
+		}
+	}
+	return append(tokens, exprToken{tokEOF, "", len(src)}), nil
+}
+
+// exprParser 再帰下降で式を構文木にする。
+type exprParser struct {
+	tokens []exprToken
+	i      int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept 次の字句がopsのいずれかの演算子なら読み進めて返す。
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.i++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return exprSyntaxError(p.peek().pos)
+	}
+	return nil
+}
+
+// binary 左結合の二項演算子の並びを読む。
+func (p *exprParser) binary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op, l, r}
+	}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.binary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.binary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<=", ">=", "<", ">"); ok {
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op, l, r}, nil
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.binary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.binary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("-", "!"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op, x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, exprSyntaxError(t.pos)
+		}
+		return &literalNode{numberValue(n)}, nil
+	case tokString:
+		return &literalNode{stringValue(t.text)}, nil
+	case tokSection:
+		if len(t.text) == 0 {
+			return nil, exprSyntaxError(t.pos)
+		}
+		return &sectionNode{t.text}, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literalNode{boolValue(true)}, nil
+		case "false":
+			return &literalNode{boolValue(false)}, nil
+		case "null":
+			return &literalNode{nullValue}, nil
+		}
+		return p.parseCall(t)
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, exprSyntaxError(t.pos)
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	fn, ok := exprFuncs[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("%w(%v)", ErrorUnknownFunction, name.text)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make([]exprNode, 0)
+	if _, ok := p.accept(")"); !ok {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("%w(%v)", ErrorFunctionArgs, name.text)
+	}
+	return &callNode{fn, args}, nil
+}
+
+// parseExpr 式を構文木にする。
+func parseExpr(src string) (exprNode, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, exprSyntaxError(t.pos)
+	}
+	return x, nil
+}
@@ -0,0 +1,241 @@
+package dpsh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// exprTestSrc 式から参照する文書
+const exprTestSrc = `@date occured: 2020/1/1
+@log: a(2020/1/2)
+
+b(2020/1/5 13:30)
+@count: 3
+@price: ¥1,200.5
+@labels: ui, crash
+`
+
+// evalString exprTestSrcの文書で式を評価した値を文字列にする。値がなければ「null」を返す。
+func evalString(t *testing.T, src string) (string, error) {
+	t.Helper()
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "date occured", "type": "date" },
+			{ "name": "log", "type": "log" },
+			{ "name": "count", "type": "number" },
+			{ "name": "price", "type": "decimal" },
+			{ "name": "labels", "type": "tags" }
+		]
+	}`)
+	doc := loadTestDocs(t, config, "a.txt", exprTestSrc)[0]
+	x, err := parseExpr(src)
+	if err != nil {
+		return "", err
+	}
+	v, err := x.eval(&exprContext{doc: doc, now: testNow})
+	if v.kind == exprNull {
+		return "null", err
+	}
+	return v.String(), err
+}
+
+func TestExprPrecedence(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"10 - 4 - 3", "3"},
+		{"12 / 2 / 3", "2"},
+		{"7 % 4 + 1", "4"},
+		{"-2 * 3", "-6"},
+		{"--2", "2"},
+		{"1 + 2 == 3", "true"},
+		{"1 + 2 > 2 * 2", "false"},
+		{"!1 == false", "true"},
+		{"1 || 0 && 0", "true"},
+		{"(1 || 0) && 0", "false"},
+		{"1 < 2 && 2 < 1 || 3 >= 3", "true"},
+		{`"a" + 1 + 2`, "a12"},
+		{`1 + 2 + "a"`, "3a"},
+		{"0.1 + 0.2", "0.3"},
+		{"1 / 3", "0.333333"},
+		{"TRUE != False", "true"},
+		{"null == null", "true"},
+		{"null == 0", "false"},
+		{"null + 1", "null"},
+		{"-null", "null"},
+		{`"a\"b"`, `a"b`},
+	}
+	for _, tt := range tests {
+		if actual, err := evalString(t, tt.src); err != nil || actual != tt.expected {
+			t.Errorf("%q: %q, %v", tt.src, actual, err)
+		}
+	}
+}
+
+func TestExprFunctions(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		// セクションの値はセクションの型で解析した値になる。
+		{"[count] * 2", "6"},
+		{"[price] + 0.5", "1201"},
+		{"[labels]", "ui, crash"},
+		{"[log]", "a\n\nb"},
+		{"[none]", "null"},
+		// セクション名は文書のセクション名と同じように正規化する。
+		{"[date  occured]", "2020-01-01"},
+		{"[　date occured\t]", "2020-01-01"},
+		// 日時の計算
+		{"today() - [date occured]", "9"},
+		{"now() - today()", "0.375"},
+		{"[date occured] + 31", "2020-02-01"},
+		{"1 + [date occured]", "2020-01-02"},
+		{"[date occured] - 1", "2019-12-31"},
+		{"[date occured] + 0.5", "2020-01-01 12:00"},
+		{"now()", "2020-01-10 09:00"},
+		{"today()", "2020-01-10"},
+		{"[date occured] < today()", "true"},
+		{`date("2020/2/1") - [date occured]`, "31"},
+		{`date("2020/2/1 10:30")`, "2020-02-01 10:30"},
+		{`number("1,234.5") + 1`, "1235.5"},
+		// ログの日付
+		{"firstlog([log])", "2020-01-02"},
+		{"lastlog([log])", "2020-01-05 13:30"},
+		{"lastlog([log]) - firstlog([log])", "3.5625"},
+		{"lastlog([none])", "null"},
+		{"firstlog([count])", "null"},
+		// 条件と値のないもの
+		{`if([count] > 2, "many", "few")`, "many"},
+		{"if([none], 1, 2)", "2"},
+		{"if(1, 2, 1 / 0)", "2"},
+		{"coalesce([none], [count], 1)", "3"},
+		{"coalesce([none], null)", "null"},
+		{"coalesce(lastlog([none]), [date occured])", "2020-01-01"},
+		{"isempty([none])", "true"},
+		{`isempty("")`, "true"},
+		{"isempty([count])", "false"},
+		{"count([labels])", "2"},
+		{"count([log])", "2"},
+		{"count([none])", "0"},
+		{"min(3, [none], 1)", "1"},
+		{"max([date occured], today())", "2020-01-10"},
+		{"round(2.5) + floor(-1.5) + ceil(0.1) + abs(-1)", "3"},
+		{"ROUND(1.4)", "1"},
+	}
+	for _, tt := range tests {
+		if actual, err := evalString(t, tt.src); err != nil || actual != tt.expected {
+			t.Errorf("%q: %q, %v", tt.src, actual, err)
+		}
+	}
+}
+
+func TestExprSyntaxError(t *testing.T) {
+	tests := []struct {
+		src string
+		pos string
+	}{
+		{"1 +", "(4文字目)"},
+		{"(1", "(3文字目)"},
+		{"1 2", "(3文字目)"},
+		{"[a", "(1文字目)"},
+		{"[ ]", "(1文字目)"},
+		{`"abc`, "(1文字目)"},
+		{"1 # 2", "(3文字目)"},
+		{"1..2", "(1文字目)"},
+		{"1 < 2 < 3", "(7文字目)"},
+		{"if(1, 2", "(8文字目)"},
+		{"today", "(6文字目)"},
+		{"", "(1文字目)"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpr(tt.src)
+		if !errors.Is(err, ErrorExprSyntax) || !strings.HasSuffix(err.Error(), tt.pos) {
+			t.Errorf("%q: %v", tt.src, err)
+		}
+	}
+}
+
+func TestExprError(t *testing.T) {
+	tests := []struct {
+		src string
+		err error
+	}{
+		{"foo(1)", ErrorUnknownFunction},
+		{"1 + Foo()", ErrorUnknownFunction},
+		{"if(1, 2)", ErrorFunctionArgs},
+		{"today(1)", ErrorFunctionArgs},
+		{"coalesce()", ErrorFunctionArgs},
+		{"1 / 0", ErrorDivisionByZero},
+		{"1 % 0", ErrorDivisionByZero},
+		{`"a" - 1`, ErrorExprType},
+		{`-"a"`, ErrorExprType},
+		{`1 < "a"`, ErrorExprType},
+		{"[date occured] * 2", ErrorExprType},
+		{`date("2020/2/30")`, ErrorExprType},
+		{`number("x")`, ErrorExprType},
+		{`abs("x")`, ErrorExprType},
+	}
+	for _, tt := range tests {
+		if actual, err := evalString(t, tt.src); !errors.Is(err, tt.err) {
+			t.Errorf("%q: %q, %v", tt.src, actual, err)
+		}
+	}
+	// 未知の関数は名前を示す。
+	if _, err := parseExpr("foo(1)"); err == nil || !strings.Contains(err.Error(), "(foo)") {
+		t.Error(err)
+	}
+}
+
+func TestComputedColumns(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "double", "type": "number", "expr": "[a] * 2" },
+			{ "name": "a", "type": "number", "default": "5" },
+			{ "name": "due", "type": "date", "expr": "[a  ] + [start]", "default": "2000/1/1" },
+			{ "name": "start", "type": "date" }
+		],
+		"computed": [
+			{ "name": "sum", "type": "number", "expr": "[double] + [a]" },
+			{ "name": "label", "expr": "if([sum] > 10, \"big\", \"small\")" },
+			{ "name": "bad", "expr": "[a] / 0" }
+		]
+	}`)
+	src := `@double: 1
+@start: 2020/1/1
+@label: 書かれた値は使わない
+`
+	doc := loadTestDocs(t, config, "a.txt", src)[0]
+	// 式で計算するカラムは、式のないカラムの前処理の後で定義された順に計算する。
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"double", "10"},
+		{"due", "2020-01-06"},
+		{"sum", "15"},
+		{"label", "big"},
+	}
+	for _, tt := range tests {
+		sec := doc.Lookup(tt.name)
+		if sec == nil || sec.Error != nil || sec.PeekString() != tt.value || !sec.Derived {
+			t.Errorf("%s: %+v", tt.name, sec)
+		}
+	}
+	if SectionDate(doc.Lookup("due")) == nil || sectionNumber(doc.Lookup("sum")) != 15 {
+		t.Error("Data")
+	}
+	if sec := doc.Lookup("bad"); sec == nil || !errors.Is(sec.Error, ErrorDivisionByZero) {
+		t.Error(sec)
+	}
+
+	// 式の値がなければ既定値を使う。
+	doc = loadTestDocs(t, config, "b.txt", "@title: t\n")[0]
+	if sec := doc.Lookup("due"); sec == nil || sec.PeekString() != "2000/1/1" {
+		t.Error(sec)
+	}
+}
@@ -0,0 +1,103 @@
+package dpsh
+
+import (
+	"strings"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// Keyer セクションの値を絞り込みや分類のためのキーの集合として返すValueType。
+// ValueTypeがこのインターフェースを実装していなければ、セクションの最初の値をキーとする。
+type Keyer interface {
+	Keys(sec *dptxt.Section) []string
+}
+
+// KeyLabeler キーを表示するための名前を返すValueType。HTMLで分類の見出しを表示するときに使う。
+type KeyLabeler interface {
+	KeyLabel(config *DustpanConfig, key string) string
+}
+
+// FilterConfig 設定ファイルから読み込んだ絞り込みの設定を格納する構造体
+type FilterConfig struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"` // セクションのキーがこれらのいずれかに一致する文書だけを残す
+}
+
+// DocGroup 分類した文書の組
+type DocGroup struct {
+	Key  string // キーのない文書の組では空文字列
+	Docs []*dptxt.Document
+}
+
+// sectionKeys セクションのキーを返す。
+func sectionKeys(vt ValueType, sec *dptxt.Section) []string {
+	if k, ok := vt.(Keyer); ok {
+		return k.Keys(sec)
+	}
+	if sec == nil || len(sec.PeekString()) == 0 {
+		return nil
+	}
+	return []string{sec.PeekString()}
+}
+
+// matchKeys keysのいずれかがvaluesのいずれかに一致するかを返す。大文字と小文字は区別しない。
+func matchKeys(keys []string, values []string) bool {
+	for _, k := range keys {
+		for _, v := range values {
+			if strings.EqualFold(k, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterDocs 設定の絞り込みの条件をすべて満たす文書だけを返す。前処理の後に呼び出す。
+func FilterDocs(config *DustpanConfig, docs []*dptxt.Document) []*dptxt.Document {
+	if len(config.Filters) == 0 {
+		return docs
+	}
+	filtered := make([]*dptxt.Document, 0, len(docs))
+	for _, doc := range docs {
+		ok := true
+		for _, f := range config.Filters {
+			if !matchKeys(sectionKeys(config.columnType(f.Name), doc.Lookup(f.Name)), f.Values) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered
+}
+
+// GroupDocs nameという名前のセクションのキーごとに文書を分類する。複数のキーを持つ文書は、それぞれの組に含まれる。
+// 組はdocsの中で最初に現われた順に並べ、キーのない文書の組は最後にする。組の中ではdocsの順を保つ。
+func GroupDocs(config *DustpanConfig, name string, docs []*dptxt.Document) []DocGroup {
+	vt := config.columnType(name)
+	groups := make([]DocGroup, 0)
+	index := make(map[string]int)
+	var nokey []*dptxt.Document
+	for _, doc := range docs {
+		keys := sectionKeys(vt, doc.Lookup(name))
+		if len(keys) == 0 {
+			nokey = append(nokey, doc)
+			continue
+		}
+		for _, k := range keys {
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, DocGroup{Key: k})
+			}
+			groups[i].Docs = append(groups[i].Docs, doc)
+		}
+	}
+	if len(nokey) > 0 {
+		groups = append(groups, DocGroup{Docs: nokey})
+	}
+	return groups
+}
@@ -24,27 +24,52 @@ var scriptClose = []byte("</script>")
 
 var divErrFmt string = `<div class="dp-err" data-msg="%v"></div>`
 var divExpire []byte = []byte(`<div class="dp-expired"></div>`)
-var divSecDateFmt string = `<div class="dp-date" data-year="%v" data-month="%v" data-day="%v">%d/%02d/%02d</div>`
-var divSecDateExpiredFmt string = `<div class="dp-date dp-expired" data-year="%d" data-month="%d" data-day="%d">%d/%02d/%02d</div>`
-var divParaDateFmt string = `<div class="dp-date" data-year="%v" data-month="%v" data-day="%v">(%d/%02d/%02d)</div>`
-var divParaDateWithSuffixFmt string = `<div class="dp-date" data-year="%d" data-month="%d" data-day="%d" data-suffix="%v">(%d/%02d/%02d %v)</div>`
+var divSecDateFmt string = `<div class="dp-date" %v>%v</div>`
+var divSecDateExpiredFmt string = `<div class="dp-date dp-expired" %v>%v</div>`
+var divParaDateFmt string = `<div class="dp-date" %v>(%v)</div>`
+var divParaDateWithSuffixFmt string = `<div class="dp-date" %v data-suffix="%v">(%v %v)</div>`
+var dateAttrFmt string = `data-year="%d" data-month="%d" data-day="%d"`
+var clockAttrFmt string = ` data-hour="%d" data-minute="%d" data-datetime="%v"`
 
 var pOpen []byte = []byte(`<div class="dp-p">`)
 var pClose []byte = []byte("</div>")
 
+var preOpenFmt string = `<pre class="dp-pre"><code data-lang="%v">`
+var preClose []byte = []byte("</code></pre>")
+
+var codeOpen []byte = []byte(`<code class="dp-code">`)
+var codeClose []byte = []byte("</code>")
+var emOpen []byte = []byte("<em>")
+var emClose []byte = []byte("</em>")
+var linkFmt string = `<a class="dp-link" href="%v">%v</a>`
+var refFmt string = `<a class="dp-ref" href="#%v">#%v</a>`
+
+var checkboxOpen []byte = []byte(`<input type="checkbox" class="dp-check" disabled>`)
+var checkboxChecked []byte = []byte(`<input type="checkbox" class="dp-check" disabled checked>`)
+var divProgressFmt string = `<div class="dp-progress" data-done="%d" data-total="%d" data-percent="%d"><progress max="%d" value="%d"></progress>%v (%d%%)</div>`
+
 var tdOpenFmt string = `<div class="dp-c" data-section="%v">`
+var tdOpenDerivedFmt string = `<div class="dp-c dp-derived" data-section="%v">`
 var tdClose []byte = []byte("</div>")
 
-var trOpenFmt string = `<div class="dp-r" data-filename="%v">`
+var trOpenFmt string = `<div class="dp-r" id="%v" data-filename="%v">`
+var trOpenErrFmt string = `<div class="dp-r dp-doc-err" id="%v" data-filename="%v">`
+var trOpenNoIDFmt string = `<div class="dp-r" data-filename="%v">`
+var trOpenNoIDErrFmt string = `<div class="dp-r dp-doc-err" data-filename="%v">`
 var trOpen []byte = []byte(`<div class="dp-r">`)
 var trClose []byte = []byte("</div>")
 
 var theadOpen []byte = []byte(`<div class="dp-h">`)
 var theadClose []byte = []byte("</div>")
 
+var trGroupFmt string = `<div class="dp-r dp-group" data-group="%v"><div class="dp-c">%v</div></div>`
+
 var tbodyOpen []byte = []byte(`<div class="dp-b">`)
 var tbodyClose []byte = []byte("</div>")
 
+var tfootOpen []byte = []byte(`<div class="dp-f">`)
+var tfootClose []byte = []byte("</div>")
+
 const defaultTitle = "Dustpan HTML"
 
 var contentOpen1 string = `<!DOCTYPE html>
@@ -54,7 +79,7 @@ var contentOpen1 string = `<!DOCTYPE html>
 <meta http-equiv="X-UA-Compatible" content="IE=Edge" />
 <title>%s</title>`
 
-var defaultstyle []byte = []byte(`body{background-color:#fff}body,html{padding:0;margin:0}body{font-family:Meiryo UI;font-size:9pt}.dp-heading{font-size:2em;margin:10pt;display:flex}.dp-heading>.dp-title{flex:initial}.dp-heading>.dp-update{font-size:.5em;flex:auto;text-align:right}.dp-heading>.dp-title:after{content:attr(data-title)}.dp-heading>.dp-update:after{content:attr(data-date) " "attr(date-time) " 更新"}.dp-t .dp-h{width:100%;font-weight:700}.dp-t,.dp-t .dp-b{width:100%}.dp-t .dp-r{width:100%;display:flex;justify-content:stretch;flex-wrap:nowrap;flex-direction:row;align-items:stretch}.dp-t .dp-r>.dp-c{flex-shrink:0;padding:3pt}.dp-t>.dp-b>.dp-r:nth-child(n+2){border-style:solid;border-color:#999;border-width:1px 0 0}.dp-t .dp-r>.dp-c:nth-child(n+2){border-style:solid;border-color:#999;border-width:0 0 0 1px}.dp-t .dp-h .dp-r{white-space:nowrap;vertical-align:bottom;text-align:center;border-bottom-width:3px;border-bottom-style:double;border-bottom-color:#999}.dp-t>.dp-b>.dp-r>.dp-c{vertical-align:top}.dp-t>.dp-b>.dp-r>.dp-c:empty{background-color:#eee;text-align:center}.dp-t .dp-b .dp-r .dp-c:empty:before{content:"?"}.dp-t>.dp-b>.dp-r>.dp-c .dp-err{display:inline-block;background-color:red;color:#fff;font-weight:700;font-size:.8em;padding:.1em}.dp-t>.dp-b>.dp-r>.dp-c .dp-err:before{content:"エラー："}.dp-t>.dp-b>.dp-r>.dp-c .dp-err:after{content:attr(data-msg)}.dp-t>.dp-b>.dp-r>.dp-c>.dp-date{text-align:center}.dp-t>.dp-b>.dp-r>.dp-c>.dp-date.dp-expired{color:red;font-weight:700}.dp-t>.dp-b>.dp-r>.dp-c .dp-p{padding-top:1.5em}.dp-t>.dp-b>.dp-r>.dp-c .dp-p:first-child{padding-top:0}.dp-t>.dp-b>.dp-r>.dp-c .dp-p:last-child{padding-bottom:0}.dp-t>.dp-b>.dp-r>.dp-c .dp-p>.dp-date{display:inline}@media print{body,html{margin:0;padding:0}.dp-heading{display:none}.dp-t{font-size:7pt;border:1px solid #999;box-sizing:border-box}.dp-t .dp-h{break-inside:avoid}.dp-t .dp-b .dp-r{break-inside:auto}.dp-t .dp-b .dp-r .dp-c .dp-p{break-inside:avoid}.dp-t .dp-b .dp-r .dp-c:empty{background-color:transparent}.dp-t .dp-b .dp-r .dp-c .dp-err{display:none}}`)
+var defaultstyle []byte = []byte(`body{background-color:#fff}body,html{padding:0;margin:0}body{font-family:Meiryo UI;font-size:9pt}.dp-heading{font-size:2em;margin:10pt;display:flex}.dp-heading>.dp-title{flex:initial}.dp-heading>.dp-update{font-size:.5em;flex:auto;text-align:right}.dp-heading>.dp-title:after{content:attr(data-title)}.dp-heading>.dp-update:after{content:attr(data-date) " "attr(date-time) " 更新"}.dp-t .dp-h{width:100%;font-weight:700}.dp-t,.dp-t .dp-b{width:100%}.dp-t .dp-r{width:100%;display:flex;justify-content:stretch;flex-wrap:nowrap;flex-direction:row;align-items:stretch}.dp-t .dp-r>.dp-c{flex-shrink:0;padding:3pt}.dp-t>.dp-b>.dp-r:nth-child(n+2){border-style:solid;border-color:#999;border-width:1px 0 0}.dp-t .dp-r>.dp-c:nth-child(n+2){border-style:solid;border-color:#999;border-width:0 0 0 1px}.dp-t .dp-h .dp-r{white-space:nowrap;vertical-align:bottom;text-align:center;border-bottom-width:3px;border-bottom-style:double;border-bottom-color:#999}.dp-t>.dp-b>.dp-r>.dp-c{vertical-align:top}.dp-t>.dp-b>.dp-r>.dp-c:empty{background-color:#eee;text-align:center}.dp-t .dp-b .dp-r .dp-c:empty:before{content:"?"}.dp-t>.dp-b>.dp-r>.dp-c .dp-err{display:inline-block;background-color:red;color:#fff;font-weight:700;font-size:.8em;padding:.1em}.dp-t>.dp-b>.dp-r>.dp-c .dp-err:before{content:"エラー："}.dp-t>.dp-b>.dp-r>.dp-c .dp-err:after{content:attr(data-msg)}.dp-t>.dp-b>.dp-r>.dp-c>.dp-date{text-align:center}.dp-t>.dp-b>.dp-r>.dp-c>.dp-date.dp-expired{color:red;font-weight:700}.dp-t>.dp-b>.dp-r>.dp-c .dp-p{padding-top:1.5em}.dp-t>.dp-b>.dp-r>.dp-c .dp-p:first-child{padding-top:0}.dp-t>.dp-b>.dp-r>.dp-c .dp-p:last-child{padding-bottom:0}.dp-t>.dp-b>.dp-r>.dp-c .dp-p>.dp-date{display:inline}.dp-t>.dp-b>.dp-r>.dp-c .dp-pre{margin:0;padding:.3em;overflow-x:auto;background-color:#f6f6f6;font-family:monospace}.dp-t>.dp-b>.dp-r>.dp-c .dp-code{padding:0 .2em;background-color:#f0f0f0;font-family:monospace}.dp-t>.dp-b>.dp-r.dp-doc-err{background-color:#fff0f0}.dp-t>.dp-b>.dp-r>.dp-c .dp-check{margin:0 .3em 0 0;vertical-align:middle}.dp-t>.dp-b>.dp-r>.dp-c .dp-progress>progress{width:5em;margin-right:.3em;vertical-align:middle}.dp-t>.dp-b>.dp-r>.dp-c>.dp-enum{text-align:center}.dp-t>.dp-b>.dp-r>.dp-c .dp-tag{display:inline-block;margin:0 .3em .2em 0;padding:0 .5em;border:1px solid #999;border-radius:.8em;background-color:#f0f0f0}.dp-t>.dp-b>.dp-r.dp-group{font-weight:700;background-color:#f6f6f6}.dp-t>.dp-b>.dp-r.dp-group>.dp-c{flex-grow:1}.dp-t>.dp-b>.dp-r>.dp-c>.dp-estimate,.dp-t>.dp-b>.dp-r>.dp-c>.dp-number{text-align:right}.dp-t .dp-f .dp-r{font-weight:700;border-top:3px double #999}.dp-t .dp-f .dp-r>.dp-c{text-align:right}.dp-t>.dp-b>.dp-r>.dp-c .dp-person{display:inline-block;margin-right:.5em}.dp-t>.dp-b>.dp-r>.dp-c .dp-refs>.dp-ref{margin-right:.5em}.dp-t>.dp-b>.dp-r:target{background-color:#ffffe0}.dp-t>.dp-b>.dp-r>.dp-c.dp-derived{color:#777;font-style:italic}@media print{body,html{margin:0;padding:0}.dp-heading{display:none}.dp-t{font-size:7pt;border:1px solid #999;box-sizing:border-box}.dp-t .dp-h{break-inside:avoid}.dp-t .dp-b .dp-r{break-inside:auto}.dp-t .dp-b .dp-r .dp-c .dp-p{break-inside:avoid}.dp-t .dp-b .dp-r .dp-c:empty{background-color:transparent}.dp-t .dp-b .dp-r .dp-c .dp-err{display:none}}`)
 var defaultColumnWithWidth string = ".dp-c[data-section=\"%s\"]{flex-grow:0;flex-basis:%s;width:%s;}"
 var defaultColumnWithoutWidth string = ".dp-c[data-section=\"%s\"]{flex-grow:1;width:0px;}"
 
@@ -78,37 +103,98 @@ func byteIsNilOrEmpty(b []byte) bool {
 	return false
 }
 
-func htmlWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
+func htmlWriteInlines(nodes []*dptxt.Inline, w *bufio.Writer) error {
+	var err error
+	for _, n := range nodes {
+		switch n.Kind {
+		case dptxt.InlineCode:
+			_, err = w.Write(codeOpen)
+			if err == nil {
+				_, err = w.WriteString(html.EscapeString(n.Text))
+			}
+			if err == nil {
+				_, err = w.Write(codeClose)
+			}
+		case dptxt.InlineEmphasis:
+			_, err = w.Write(emOpen)
+			if err == nil {
+				err = htmlWriteInlines(n.Children, w)
+			}
+			if err == nil {
+				_, err = w.Write(emClose)
+			}
+		case dptxt.InlineLink:
+			t := html.EscapeString(n.Text)
+			_, err = w.WriteString(fmt.Sprintf(linkFmt, t, t))
+		case dptxt.InlineRef:
+			t := html.EscapeString(n.Text)
+			_, err = w.WriteString(fmt.Sprintf(refFmt, t, t))
+		default:
+			_, err = w.WriteString(html.EscapeString(n.Text))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func htmlWriteParagraph(para *dptxt.Paragraph, era bool, w *bufio.Writer) error {
 	_, err := w.Write(pOpen)
 	if err != nil {
 		return err
 	}
 
+	// コードブロックは改行をそのまま残してpre要素に入れる。
+	linesep := br
+	if para.Kind == dptxt.VerbatimParagraph {
+		_, err = w.WriteString(fmt.Sprintf(preOpenFmt, html.EscapeString(para.Info)))
+		if err != nil {
+			return err
+		}
+		linesep = sepNewline
+	}
+	// チェックリストの項目はチェックボックスにする。
+	items := make(map[int]dptxt.CheckItem)
+	for _, item := range para.CheckItems() {
+		items[item.Index] = item
+	}
 	sep := sepEmpty
-	for _, v := range para.Value {
+	for i, v := range para.Inlines() {
 		_, err = w.Write(sep)
 		if err != nil {
 			return err
 		}
-		_, err = w.WriteString(html.EscapeString(string(v)))
+		if item, ok := items[i]; ok {
+			if item.Done {
+				_, err = w.Write(checkboxChecked)
+			} else {
+				_, err = w.Write(checkboxOpen)
+			}
+			if err != nil {
+				return err
+			}
+			v = dptxt.ParseInline(item.Text)
+		}
+		err = htmlWriteInlines(v, w)
+		if err != nil {
+			return err
+		}
+		sep = linesep
+	}
+	if para.Kind == dptxt.VerbatimParagraph {
+		_, err = w.Write(preClose)
 		if err != nil {
 			return err
 		}
-		sep = br
 	}
 	if para.Time != nil {
-		year, month, day := para.Time.Date()
+		attr, text := htmlDate(para.Time, para.HasClock, era)
 		if len(para.TimeSuffix) == 0 {
-			_, err = w.WriteString(fmt.Sprintf(divParaDateFmt, year, int(month), day, year, int(month), day))
+			_, err = w.WriteString(fmt.Sprintf(divParaDateFmt, attr, text))
 		} else {
-			_, err = w.WriteString(fmt.Sprintf(divParaDateWithSuffixFmt, year,
-				int(month),
-				day,
-				html.EscapeString(string(para.TimeSuffix)),
-				year,
-				int(month),
-				day,
-				html.EscapeString(string(para.TimeSuffix))))
+			suffix := html.EscapeString(string(para.TimeSuffix))
+			_, err = w.WriteString(fmt.Sprintf(divParaDateWithSuffixFmt, attr, suffix, text, suffix))
 		}
 		if err != nil {
 			return err
@@ -133,31 +219,47 @@ func htmlWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 	return nil
 }
 
-func htmlWriteSection(sec *dptxt.Section, secname string, w *bufio.Writer) error {
-	// secがnilでも開始タグと閉じタグは出力する。
-	_, err := w.WriteString(fmt.Sprintf(tdOpenFmt, html.EscapeString(secname)))
+// htmlDate 日付のdata属性と表示する文字列を返す。時刻が指定されていれば時刻も含める。
+// eraがtrueなら元号で表示する。
+func htmlDate(t *time.Time, clock bool, era bool) (string, string) {
+	year, month, day := t.Date()
+	attr := fmt.Sprintf(dateAttrFmt, year, int(month), day)
+	text := fmt.Sprintf("%d/%02d/%02d", year, int(month), day)
+	if era {
+		if s, ok := dptxt.FormatEraDate(year, int(month), day); ok {
+			text = s
+		}
+	}
+	if clock {
+		attr += fmt.Sprintf(clockAttrFmt, t.Hour(), t.Minute(), t.Format(time.RFC3339))
+		text += " " + clockText(t)
+	}
+	return attr, text
+}
+
+func htmlWriteSection(config *DustpanConfig, sec *dptxt.Section, secname string, docerrs []string, w *bufio.Writer) error {
+	// secがnilでも開始タグと閉じタグは出力する。既定値などから作られたセクションには印を付ける。
+	tdFmt := tdOpenFmt
+	if sec != nil && sec.Derived {
+		tdFmt = tdOpenDerivedFmt
+	}
+	_, err := w.WriteString(fmt.Sprintf(tdFmt, html.EscapeString(secname)))
 	if err != nil {
 		return err
 	}
 
+	// 文書全体のエラーはセルの先頭に出力する。
+	for _, msg := range docerrs {
+		_, err = w.WriteString(fmt.Sprintf(divErrFmt, html.EscapeString(msg)))
+		if err != nil {
+			return err
+		}
+	}
+
 	if sec != nil {
-		if sec.Time != nil {
-			year, month, day := sec.Time.Date()
-			if sec.Expired {
-				_, err = w.WriteString(fmt.Sprintf(divSecDateExpiredFmt, year, int(month), day, year, int(month), day))
-			} else {
-				_, err = w.WriteString(fmt.Sprintf(divSecDateFmt, year, int(month), day, year, int(month), day))
-			}
-			if err != nil {
-				return err
-			}
-		} else {
-			for _, p := range sec.Value {
-				err = htmlWriteParagraph(p, w)
-				if err != nil {
-					return err
-				}
-			}
+		err = config.columnType(secname).WriteHTML(config, sec, w)
+		if err != nil {
+			return err
 		}
 
 		if sec.Error != nil {
@@ -180,16 +282,33 @@ func htmlWriteSection(sec *dptxt.Section, secname string, w *bufio.Writer) error
 	return nil
 }
 
-func htmlWriteDocument(config *DustpanConfig, doc *dptxt.Document, w *bufio.Writer) error {
-	_, err := w.WriteString(fmt.Sprintf(trOpenFmt, html.EscapeString(doc.Filename)))
+// htmlWriteDocument 文書を行として書き出す。idがfalseなら行にidを付けない。
+// 分類した表では同じ文書が複数の組に現われるので、idは最初の行にだけ付けて重複させない。
+func htmlWriteDocument(config *DustpanConfig, doc *dptxt.Document, id bool, w *bufio.Writer) error {
+	docerrs := docErrorMessages(doc)
+	var err error
+	if id {
+		trFmt := trOpenFmt
+		if len(docerrs) > 0 {
+			trFmt = trOpenErrFmt
+		}
+		_, err = w.WriteString(fmt.Sprintf(trFmt, html.EscapeString(docBasename(doc)), html.EscapeString(doc.Filename)))
+	} else {
+		trFmt := trOpenNoIDFmt
+		if len(docerrs) > 0 {
+			trFmt = trOpenNoIDErrFmt
+		}
+		_, err = w.WriteString(fmt.Sprintf(trFmt, html.EscapeString(doc.Filename)))
+	}
 	if err != nil {
 		return err
 	}
 	for _, cname := range config.HTML.DisplayColumns {
-		err = htmlWriteSection(doc.Sections[cname], cname, w)
+		err = htmlWriteSection(config, doc.Lookup(cname), cname, docerrs, w)
 		if err != nil {
 			return err
 		}
+		docerrs = nil
 	}
 	_, err = w.Write(trClose)
 	if err != nil {
@@ -324,10 +443,33 @@ func WriteHTMLTo(dst io.Writer, basepath string, config *DustpanConfig, docs []*
 	if err != nil {
 		return err
 	}
-	for _, d := range docs {
-		err = htmlWriteDocument(config, d, w)
-		if err != nil {
-			return err
+	if len(config.HTML.Group) > 0 {
+		// 組ごとに見出しの行を入れる。
+		labeler, _ := config.columnType(config.HTML.Group).(KeyLabeler)
+		written := make(map[*dptxt.Document]bool)
+		for _, g := range GroupDocs(config, config.HTML.Group, docs) {
+			label := g.Key
+			if labeler != nil && len(g.Key) > 0 {
+				label = labeler.KeyLabel(config, g.Key)
+			}
+			_, err = w.WriteString(fmt.Sprintf(trGroupFmt, html.EscapeString(g.Key), html.EscapeString(label)))
+			if err != nil {
+				return err
+			}
+			for _, d := range g.Docs {
+				err = htmlWriteDocument(config, d, !written[d], w)
+				if err != nil {
+					return err
+				}
+				written[d] = true
+			}
+		}
+	} else {
+		for _, d := range docs {
+			err = htmlWriteDocument(config, d, true, w)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err = w.Write(tbodyClose)
@@ -335,6 +477,40 @@ func WriteHTMLTo(dst io.Writer, basepath string, config *DustpanConfig, docs []*
 		return err
 	}
 
+	if config.HTML.Total {
+		// 合計の行を出力する。
+		_, err = w.Write(tfootOpen)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(trOpen)
+		if err != nil {
+			return err
+		}
+		for i, t := range config.columnTotals(config.HTML.DisplayColumns, docs) {
+			_, err = w.WriteString(fmt.Sprintf(tdOpenFmt, config.HTML.DisplayColumns[i]))
+			if err != nil {
+				return err
+			}
+			_, err = w.WriteString(html.EscapeString(t))
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(tdClose)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = w.Write(trClose)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(tfootClose)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = w.WriteString(contentClose)
 	if err != nil {
 		return err
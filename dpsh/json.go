@@ -12,16 +12,43 @@ import (
 )
 
 var jsonErrFmt string = `, "error":"%v"`
-var jsonSecDateFmt string = `"date":{ "year":%d, "month":%d, "day":%d }`
-var jsonSecDateExpiredFmt string = `"date":{ "year":%d, "month":%d, "day":%d, "expired":true }`
-var jsonParaDateFmt string = `, "date":{ "year":%d, "month":%d, "day":%d }`
-var jsonParaDateWithSuffixFmt string = `, "date":{ "year":%d, "month":%d, "day":%d, "suffix":"%v" }`
+var jsonSecDateFmt string = `"date":{ %v }`
+var jsonSecDateExpiredFmt string = `"date":{ %v, "expired":true }`
+var jsonParaDateFmt string = `, "date":{ %v }`
+var jsonParaDateWithSuffixFmt string = `, "date":{ %v, "suffix":"%v" }`
+var jsonDateFieldsFmt string = `"year":%d, "month":%d, "day":%d`
+var jsonClockFieldsFmt string = `, "hour":%d, "min":%d, "sec":%d`
+var jsonZoneFieldFmt string = `, "offset":%d`
 var jsonLastUpdateFmt string = `, "lastupdate":{ "year":%d, "month":%d, "day":%d, "hour":%d, "min":%d, "sec":%d }`
 
+var jsonChecklistFmt string = `"progress":"%v", "done":%d, "total":%d, "percent":%d, `
+
+var jsonVerbatimFmt string = `, "verbatim":true, "lang":"%v"`
+
 func jsonEscapeString(s string) string {
-	s = strings.ReplaceAll(s, `\`, `\\`)
-	s = strings.ReplaceAll(s, `"`, `\"`)
-	return s
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			// その他の制御文字はJSONの文字列に含められないので\uXXXXにする。
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
 }
 
 func jsonWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
@@ -31,12 +58,12 @@ func jsonWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 	}
 
 	sep := sepEmpty
-	for _, v := range para.Value {
+	for _, v := range para.Inlines() {
 		_, err = w.Write(sep)
 		if err != nil {
 			return err
 		}
-		_, err = w.WriteString(`"` + jsonEscapeString(string(v)) + `"`)
+		_, err = w.WriteString(`"` + jsonEscapeString(dptxt.PlainText(v)) + `"`)
 		if err != nil {
 			return err
 		}
@@ -47,14 +74,19 @@ func jsonWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 		return err
 	}
 
+	if para.Kind == dptxt.VerbatimParagraph {
+		_, err = w.WriteString(fmt.Sprintf(jsonVerbatimFmt, jsonEscapeString(para.Info)))
+		if err != nil {
+			return err
+		}
+	}
+
 	if para.Time != nil {
-		year, month, day := para.Time.Date()
+		fields := jsonDateFields(para.Time, para.HasClock)
 		if len(para.TimeSuffix) == 0 {
-			_, err = w.WriteString(fmt.Sprintf(jsonParaDateFmt, year, int(month), day))
+			_, err = w.WriteString(fmt.Sprintf(jsonParaDateFmt, fields))
 		} else {
-			_, err = w.WriteString(fmt.Sprintf(jsonParaDateWithSuffixFmt, year,
-				int(month),
-				day,
+			_, err = w.WriteString(fmt.Sprintf(jsonParaDateWithSuffixFmt, fields,
 				jsonEscapeString(string(para.TimeSuffix))))
 		}
 		if err != nil {
@@ -80,61 +112,78 @@ func jsonWriteParagraph(para *dptxt.Paragraph, w *bufio.Writer) error {
 	return nil
 }
 
-func jsonWriteSection(sec *dptxt.Section, secname string, w *bufio.Writer) error {
-	// secがnilでも開始タグと閉じタグは出力する。
-	_, err := w.WriteString(fmt.Sprintf(`"%v":`, jsonEscapeString(secname)))
+// jsonDateFields 日付のフィールドを返す。時刻が指定されていれば時刻と、明示された時差(秒)も含める。
+func jsonDateFields(t *time.Time, clock bool) string {
+	year, month, day := t.Date()
+	fields := fmt.Sprintf(jsonDateFieldsFmt, year, int(month), day)
+	if clock {
+		fields += fmt.Sprintf(jsonClockFieldsFmt, t.Hour(), t.Minute(), t.Second())
+		if t.Location() != time.Local {
+			_, offset := t.Zone()
+			fields += fmt.Sprintf(jsonZoneFieldFmt, offset)
+		}
+	}
+	return fields
+}
+
+// jsonWriteValues セクションのすべてのパラグラフを"value"メンバとして書き出す。
+func jsonWriteValues(sec *dptxt.Section, w *bufio.Writer) error {
+	_, err := w.WriteString(`"value":[`)
 	if err != nil {
 		return err
 	}
-
-	if sec != nil && sec.Time == nil && len(sec.Value) == 1 && len(sec.Value[0].Value) == 1 && sec.Error == nil {
-		_, err = w.WriteString(fmt.Sprintf(`"%v"`, jsonEscapeString(string(sec.Value[0].Value[0]))))
-	} else {
-		_, err = w.WriteString("{")
-		if sec != nil {
-			if sec.Time != nil {
-				year, month, day := sec.Time.Date()
-				if sec.Expired {
-					_, err = w.WriteString(fmt.Sprintf(jsonSecDateExpiredFmt, year, int(month), day))
-				} else {
-					_, err = w.WriteString(fmt.Sprintf(jsonSecDateFmt, year, int(month), day))
-				}
-				if err != nil {
-					return err
-				}
-			} else {
-				w.WriteString(`"value":[`)
-				sep := sepEmpty
-				for _, p := range sec.Value {
-					_, err = w.Write(sep)
-					err = jsonWriteParagraph(p, w)
-					if err != nil {
-						return err
-					}
-					sep = sepComma
-				}
-				w.WriteString(`]`)
-			}
-
-			if sec.Error != nil {
-				// para.ErrorはValueErrorの想定だけど、将来的に変更するかもしれないので、Unwrapする処理を入れておく。
-				ierr := errors.Unwrap(sec.Error)
-				if ierr == nil {
-					ierr = sec.Error
-				}
-				_, err = w.WriteString(fmt.Sprintf(jsonErrFmt, jsonEscapeString(ierr.Error())))
-				if err != nil {
-					return err
-				}
-			}
+	sep := sepEmpty
+	for _, p := range sec.Value {
+		_, err = w.Write(sep)
+		if err != nil {
+			return err
 		}
+		err = jsonWriteParagraph(p, w)
+		if err != nil {
+			return err
+		}
+		sep = sepComma
+	}
+	_, err = w.WriteString(`]`)
+	return err
+}
 
-		_, err = w.WriteString(`}`)
+// jsonWriteObject セクションをオブジェクトとして書き出す。メンバはmembersで書き出し、値のエラーがあれば最後に加える。
+func jsonWriteObject(sec *dptxt.Section, w *bufio.Writer, members func(*dptxt.Section, *bufio.Writer) error) error {
+	_, err := w.WriteString("{")
+	if err != nil {
+		return err
+	}
+	err = members(sec, w)
+	if err != nil {
+		return err
+	}
+	if sec.Error != nil {
+		// para.ErrorはValueErrorの想定だけど、将来的に変更するかもしれないので、Unwrapする処理を入れておく。
+		ierr := errors.Unwrap(sec.Error)
+		if ierr == nil {
+			ierr = sec.Error
+		}
+		_, err = w.WriteString(fmt.Sprintf(jsonErrFmt, jsonEscapeString(ierr.Error())))
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	_, err = w.WriteString(`}`)
+	return err
+}
+
+func jsonWriteSection(config *DustpanConfig, sec *dptxt.Section, secname string, w *bufio.Writer) error {
+	// secがnilでも開始タグと閉じタグは出力する。
+	_, err := w.WriteString(fmt.Sprintf(`"%v":`, jsonEscapeString(secname)))
+	if err != nil {
+		return err
+	}
+	if sec == nil {
+		_, err = w.WriteString(`{}`)
+		return err
+	}
+	return config.columnType(secname).WriteJSON(sec, w)
 }
 
 func jsonWriteDocument(config *DustpanConfig, doc *dptxt.Document, w *bufio.Writer) error {
@@ -145,13 +194,52 @@ func jsonWriteDocument(config *DustpanConfig, doc *dptxt.Document, w *bufio.Writ
 	sep := sepEmpty
 	for _, cname := range config.HTML.DisplayColumns {
 		_, err = w.Write(sep)
-		err = jsonWriteSection(doc.Sections[cname], cname, w)
+		err = jsonWriteSection(config, doc.Lookup(cname), cname, w)
 		if err != nil {
 			return err
 		}
 		sep = sepComma
 	}
-	_, err = w.WriteString(`} }`)
+	_, err = w.WriteString(`}`)
+	if err != nil {
+		return err
+	}
+	if docerrs := docErrorMessages(doc); len(docerrs) > 0 {
+		_, err = w.WriteString(`, "errors":[`)
+		if err != nil {
+			return err
+		}
+		sep = sepEmpty
+		for _, msg := range docerrs {
+			_, err = w.Write(sep)
+			if err != nil {
+				return err
+			}
+			_, err = w.WriteString(`"` + jsonEscapeString(msg) + `"`)
+			if err != nil {
+				return err
+			}
+			sep = sepComma
+		}
+		_, err = w.WriteString(`]`)
+		if err != nil {
+			return err
+		}
+	}
+	// 既定値などから作られたセクションの名前
+	derived := make([]string, 0)
+	for _, cname := range config.HTML.DisplayColumns {
+		if sec := doc.Lookup(cname); sec != nil && sec.Derived {
+			derived = append(derived, `"`+jsonEscapeString(cname)+`"`)
+		}
+	}
+	if len(derived) > 0 {
+		_, err = w.WriteString(`, "derived":[` + strings.Join(derived, ",") + `]`)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteString(` }`)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,245 @@
+package dpsh
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var divPeopleOpen []byte = []byte(`<div class="dp-people">`)
+var divPeopleClose []byte = []byte("</div>")
+var spanPersonFmt string = `<span class="dp-person" data-id="%v">%v</span>`
+var aPersonFmt string = `<a class="dp-person" data-id="%v" href="mailto:%v">%v</a>`
+
+// PersonConfig 設定ファイルから読み込んだ人の定義を格納する構造体
+type PersonConfig struct {
+	ID      string   `json:"id"`      // 出力やキーに使う識別子
+	Name    string   `json:"name"`    // HTMLに表示する名前。省略した場合はidを表示する
+	Aliases []string `json:"aliases"` // 文書に書ける別名
+	Email   string   `json:"email"`
+}
+
+// DisplayName HTMLに表示する名前を返す。
+func (pc *PersonConfig) DisplayName() string {
+	if len(pc.Name) > 0 {
+		return pc.Name
+	}
+	return pc.ID
+}
+
+// names 文書に書ける名前をすべて返す。
+func (pc *PersonConfig) names() []string {
+	return append([]string{pc.ID, pc.Name, pc.Email}, pc.Aliases...)
+}
+
+// personKey 名前を比べるためのキーにする。大文字と小文字、空白の有無は区別しない。
+func personKey(name string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+// LookupPerson id、名前、別名、メールアドレスのいずれかがnameに一致する人を返す。いなければnilを返す。
+func (config *DustpanConfig) LookupPerson(name string) *PersonConfig {
+	_, pc := config.lookupPerson(name)
+	return pc
+}
+
+// lookupPerson nameに一致する人と、peopleの中での位置を返す。いなければ-1とnilを返す。
+func (config *DustpanConfig) lookupPerson(name string) (int, *PersonConfig) {
+	key := personKey(name)
+	if len(key) == 0 {
+		return -1, nil
+	}
+	for i := range config.People {
+		pc := &config.People[i]
+		for _, n := range pc.names() {
+			if personKey(n) == key {
+				return i, pc
+			}
+		}
+	}
+	return -1, nil
+}
+
+func validatePeople(people []PersonConfig) error {
+	seen := make(map[string]bool)
+	for i := range people {
+		pc := &people[i]
+		if len(personKey(pc.ID)) == 0 {
+			return ErrorNoPersonID
+		}
+		// 同じ人の中での重複は許す。
+		own := make(map[string]bool)
+		for _, n := range pc.names() {
+			k := personKey(n)
+			if len(k) == 0 || own[k] {
+				continue
+			}
+			if seen[k] {
+				return ErrorDuplicatePerson
+			}
+			own[k] = true
+			seen[k] = true
+		}
+	}
+	return nil
+}
+
+// isPersonSeparator 複数の人を区切る文字かどうか。名前に空白を含められるように、空白では区切らない。
+func isPersonSeparator(r rune) bool {
+	return r == ',' || r == '，' || r == '、'
+}
+
+// PersonValue person型のセクションの値の一人分
+type PersonValue struct {
+	*PersonConfig
+	Index int // peopleの中での位置
+}
+
+// SectionPeople セクションの人を書かれた順に返す。person型の値でなければnilを返す。
+func SectionPeople(sec *dptxt.Section) []PersonValue {
+	if sec == nil {
+		return nil
+	}
+	people, _ := sec.Data.([]PersonValue)
+	return people
+}
+
+// personType person型。値はpeopleに定義された人の名前で、カンマや読点で区切って複数の人を書ける。
+// sec.Dataには[]PersonValueを格納する。
+type personType struct {
+	TextType
+}
+
+func (personType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil {
+		return nil
+	}
+	people := make([]PersonValue, 0)
+	seen := make(map[int]bool)
+	for _, p := range sec.Value {
+		if p.Kind != dptxt.TextParagraph {
+			continue
+		}
+		for li, line := range p.Value {
+			for _, span := range fieldSpans(line, isPersonSeparator) {
+				i, pc := ctx.Config.lookupPerson(line[span[0]:span[1]])
+				if pc == nil {
+					return NewValueErrorAt(ctx.Doc.Filename, sec.Linenum, p.SpanOf(li, span[0], span[1]), ErrorUnknownPerson)
+				}
+				if !seen[i] {
+					seen[i] = true
+					people = append(people, PersonValue{pc, i})
+				}
+			}
+		}
+	}
+	sec.Data = people
+	return nil
+}
+
+// personOrder i番目の人の並べ替えのための順番を返す。いなければ定義されたどの人よりも後ろにする。
+func personOrder(people []PersonValue, i int) int {
+	if i < len(people) {
+		return people[i].Index
+	}
+	return math.MaxInt32
+}
+
+// Compare peopleに定義した順で先頭の人から比べる。人のないものが最後になる。
+func (personType) Compare(a, b *dptxt.Section) int {
+	ap := SectionPeople(a)
+	bp := SectionPeople(b)
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		if r := personOrder(ap, i) - personOrder(bp, i); r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+func (personType) Keys(sec *dptxt.Section) []string {
+	people := SectionPeople(sec)
+	if len(people) == 0 {
+		return nil
+	}
+	keys := make([]string, len(people))
+	for i, p := range people {
+		keys[i] = p.ID
+	}
+	return keys
+}
+
+// KeyLabel idの人の表示する名前を返す。
+func (personType) KeyLabel(config *DustpanConfig, key string) string {
+	if pc := config.LookupPerson(key); pc != nil {
+		return pc.DisplayName()
+	}
+	return key
+}
+
+func (ptype personType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	people := SectionPeople(sec)
+	if len(people) == 0 {
+		return ptype.TextType.WriteHTML(config, sec, w)
+	}
+	_, err := w.Write(divPeopleOpen)
+	if err != nil {
+		return err
+	}
+	for _, p := range people {
+		id := html.EscapeString(p.ID)
+		name := html.EscapeString(p.DisplayName())
+		if len(p.Email) > 0 {
+			_, err = w.WriteString(fmt.Sprintf(aPersonFmt, id, html.EscapeString(p.Email), name))
+		} else {
+			_, err = w.WriteString(fmt.Sprintf(spanPersonFmt, id, name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(divPeopleClose)
+	return err
+}
+
+func (ptype personType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if _, ok := sec.Data.([]PersonValue); !ok {
+		return ptype.TextType.WriteCsv(sec, w)
+	}
+	_, err := w.WriteString(csvEscapeString(strings.Join(ptype.Keys(sec), ", ")))
+	return err
+}
+
+func (ptype personType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	if _, ok := sec.Data.([]PersonValue); !ok {
+		return ptype.TextType.WriteJSON(sec, w)
+	}
+	_, err := w.WriteString(`[`)
+	if err != nil {
+		return err
+	}
+	sep := sepEmpty
+	for _, id := range ptype.Keys(sec) {
+		_, err = w.Write(sep)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString(`"` + jsonEscapeString(id) + `"`)
+		if err != nil {
+			return err
+		}
+		sep = sepComma
+	}
+	_, err = w.WriteString(`]`)
+	return err
+}
@@ -0,0 +1,136 @@
+package dpsh
+
+import (
+	"bufio"
+	"errors"
+	"testing"
+)
+
+const personTestConfig = `{
+	"columns": [
+		{ "name": "title", "type": "text" },
+		{ "name": "assignee", "type": "person" }
+	],
+	"people": [
+		{ "id": "tanaka", "name": "田中 太郎", "aliases": ["たなか"], "email": "tanaka@example.com" },
+		{ "id": "suzuki", "aliases": ["Suzu"] },
+		{ "id": "sato", "name": "佐藤" }
+	],
+	"order": [{ "name": "assignee" }]
+}`
+
+func TestLookupPerson(t *testing.T) {
+	config := loadTestConfig(t, personTestConfig)
+	// id、名前、別名、メールアドレスのいずれでも、大文字と小文字、空白を区別せずに探す。
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"tanaka", "tanaka"},
+		{"田中太郎", "tanaka"},
+		{"田中　太郎", "tanaka"},
+		{"たなか", "tanaka"},
+		{"Tanaka@Example.com", "tanaka"},
+		{"suzu", "suzuki"},
+		{"佐藤", "sato"},
+		{"山田", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		pc := config.LookupPerson(tt.name)
+		if (pc == nil && len(tt.id) > 0) || (pc != nil && pc.ID != tt.id) {
+			t.Errorf("%q: %+v", tt.name, pc)
+		}
+	}
+}
+
+func TestValidatePeople(t *testing.T) {
+	tests := []struct {
+		people []PersonConfig
+		err    error
+	}{
+		// 同じ人の中での重複は許す。
+		{[]PersonConfig{{ID: "a", Name: "A", Aliases: []string{"a"}}}, nil},
+		{[]PersonConfig{{ID: " "}}, ErrorNoPersonID},
+		{[]PersonConfig{{ID: "a"}, {ID: "A"}}, ErrorDuplicatePerson},
+		{[]PersonConfig{{ID: "a", Name: "Taro Yamada"}, {ID: "b", Aliases: []string{"taroyamada"}}}, ErrorDuplicatePerson},
+		{[]PersonConfig{{ID: "a", Email: "x@example.com"}, {ID: "b", Email: "X@example.com"}}, ErrorDuplicatePerson},
+	}
+	for i, tt := range tests {
+		if err := validatePeople(tt.people); err != tt.err {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+}
+
+func TestPersonColumn(t *testing.T) {
+	config := loadTestConfig(t, personTestConfig)
+	src := `@title: t
+@assignee: 佐藤、たなか
+suzu, 佐藤
+`
+	doc := loadTestDocs(t, config, "a.txt", src)[0]
+	if err := doc.Lookup("assignee").Error; err != nil {
+		t.Fatal(err)
+	}
+	// 出力には書かれた順にidを使い、重複は除く。
+	if actual := csvRow(t, config, doc); actual != `"t","sato, tanaka, suzuki"` {
+		t.Error(actual)
+	}
+	html := writeString(t, func(w *bufio.Writer) error {
+		return htmlWriteSection(config, doc.Lookup("assignee"), "assignee", nil, w)
+	})
+	expected := `<div class="dp-c" data-section="assignee"><div class="dp-people">` +
+		`<span class="dp-person" data-id="sato">佐藤</span>` +
+		`<a class="dp-person" data-id="tanaka" href="mailto:tanaka@example.com">田中 太郎</a>` +
+		`<span class="dp-person" data-id="suzuki">suzuki</span></div></div>`
+	if html != expected {
+		t.Error(html)
+	}
+	vt := config.ColumnDefs[1].ValueType()
+	json := writeString(t, func(w *bufio.Writer) error {
+		return vt.WriteJSON(doc.Lookup("assignee"), w)
+	})
+	if json != `["sato","tanaka","suzuki"]` {
+		t.Error(json)
+	}
+	if label := vt.(KeyLabeler).KeyLabel(config, "tanaka"); label != "田中 太郎" {
+		t.Error(label)
+	}
+
+	// 誤りの範囲は区切りの後ろの空白を除いた名前だけにする。
+	src = `@title: t
+@assignee: tanaka
+sato, 山田
+`
+	doc = loadTestDocs(t, config, "a.txt", src)[0]
+	var ve *ValueError
+	if !errors.As(doc.Lookup("assignee").Error, &ve) || !errors.Is(ve, ErrorUnknownPerson) || ve.Linenum != 2 {
+		t.Fatal(doc.Lookup("assignee").Error)
+	}
+	if ve.Span.Start.Line != 3 || ve.Span.Start.Column != 7 || ve.Span.End.Column != 9 {
+		t.Error(ve.Span)
+	}
+	if actual := csvRow(t, config, doc); actual != "\"t\",\"tanaka\nsato, 山田\"" {
+		t.Error(actual)
+	}
+}
+
+func TestPersonOrder(t *testing.T) {
+	config := loadTestConfig(t, personTestConfig)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@assignee: sato\n",
+		"b.txt", "@title: なし\n",
+		"c.txt", "@assignee: suzuki, sato\n",
+		"d.txt", "@assignee: suzuki\n",
+	)
+	SortDocs(config, docs)
+	order := ""
+	for _, doc := range docs {
+		order += docBasename(doc)
+	}
+	// peopleの順に先頭の人から比べ、人のないものは最後にする。
+	if order != "cdab" {
+		t.Error(order)
+	}
+}
@@ -0,0 +1,329 @@
+package dpsh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var divRefsOpen []byte = []byte(`<div class="dp-refs">`)
+var divRefsClose []byte = []byte("</div>")
+
+// RefValue ref型とbacklinks型のセクションの値
+type RefValue struct {
+	Targets []string // 参照する文書の、ファイル名から拡張子を除いたもの
+}
+
+// SectionRefs セクションが参照する文書の名前を書かれた順に返す。ref型とbacklinks型の値でなければnilを返す。
+func SectionRefs(sec *dptxt.Section) []string {
+	if sec == nil {
+		return nil
+	}
+	if rv, ok := sec.Data.(*RefValue); ok {
+		return rv.Targets
+	}
+	return nil
+}
+
+// refLinked セクションを参照する文書へのリンクとして書き出すかどうか。存在しない文書への参照があれば、書かれたとおりに書き出す。
+func refLinked(sec *dptxt.Section) bool {
+	if _, ok := sec.Data.(*RefValue); !ok {
+		return false
+	}
+	return !errors.Is(sec.Error, ErrorUnknownRef)
+}
+
+// Referrer 文書に書かれた値で他の文書を参照する型。ref型のカラムかどうかはこのインターフェイスで判断する。
+type Referrer interface {
+	// Refs セクションが参照する文書の名前を書かれた順に返す。
+	Refs(sec *dptxt.Section) []string
+}
+
+// refListType ref型とbacklinks型に共通の、*RefValueを値とするセクションの比較と書き出し
+type refListType struct {
+	TextType
+}
+
+// refType ref型。値は他の文書のファイル名から拡張子を除いたもので、カンマや空白で区切って複数書ける。
+// 先頭の「#」は取り除く。sec.Dataには*RefValueを格納する。存在しない文書への参照があっても、
+// 存在する文書への参照はsec.Dataに格納し、最初の存在しない文書への参照を誤りとする。
+type refType struct {
+	refListType
+}
+
+func (refType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil {
+		return nil
+	}
+	targets := make([]string, 0)
+	seen := make(map[string]bool)
+	var err error
+	for _, p := range sec.Value {
+		if p.Kind != dptxt.TextParagraph {
+			continue
+		}
+		for li, line := range p.Value {
+			for _, span := range fieldSpans(line, isTagSeparator) {
+				name := strings.TrimPrefix(line[span[0]:span[1]], "#")
+				if _, ok := ctx.Docs[name]; !ok {
+					if err == nil {
+						err = NewValueErrorAt(ctx.Doc.Filename, sec.Linenum, p.SpanOf(li, span[0], span[1]), ErrorUnknownRef)
+					}
+					continue
+				}
+				if !seen[name] {
+					seen[name] = true
+					targets = append(targets, name)
+				}
+			}
+		}
+	}
+	sec.Data = &RefValue{targets}
+	return err
+}
+
+func (refType) Refs(sec *dptxt.Section) []string {
+	return SectionRefs(sec)
+}
+
+// Compare 参照する文書の名前を書かれた順に比べる。参照のないものが最初になる。
+func (refListType) Compare(a, b *dptxt.Section) int {
+	at := SectionRefs(a)
+	bt := SectionRefs(b)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if r := strings.Compare(at[i], bt[i]); r != 0 {
+			return r
+		}
+	}
+	return len(at) - len(bt)
+}
+
+func (refListType) Keys(sec *dptxt.Section) []string {
+	return SectionRefs(sec)
+}
+
+// WriteHTML 参照する文書の行へのリンクを書き出す。
+func (rtype refListType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	if !refLinked(sec) {
+		return rtype.TextType.WriteHTML(config, sec, w)
+	}
+	_, err := w.Write(divRefsOpen)
+	if err != nil {
+		return err
+	}
+	for _, t := range SectionRefs(sec) {
+		et := html.EscapeString(t)
+		_, err = w.WriteString(fmt.Sprintf(refFmt, et, et))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(divRefsClose)
+	return err
+}
+
+func (rtype refListType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if !refLinked(sec) {
+		return rtype.TextType.WriteCsv(sec, w)
+	}
+	_, err := w.WriteString(csvEscapeString(strings.Join(SectionRefs(sec), ", ")))
+	return err
+}
+
+func (rtype refListType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	if !refLinked(sec) {
+		return rtype.TextType.WriteJSON(sec, w)
+	}
+	_, err := w.WriteString(`[`)
+	if err != nil {
+		return err
+	}
+	sep := sepEmpty
+	for _, t := range SectionRefs(sec) {
+		_, err = w.Write(sep)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString(`"` + jsonEscapeString(t) + `"`)
+		if err != nil {
+			return err
+		}
+		sep = sepComma
+	}
+	_, err = w.WriteString(`]`)
+	return err
+}
+
+// backlinksType backlinks型。baseに指定したref型のカラム(省略した場合はすべてのref型のカラム)で
+// その文書を参照している文書の一覧。文書に書かれた値は使わずに、前処理の後で求める。
+type backlinksType struct {
+	refListType
+}
+
+// Preprocess 何もしない。値はlinkDocsで求める。
+func (backlinksType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	return nil
+}
+
+// isRefColumn cdがref型のカラムかどうか
+func isRefColumn(cd *ColumnConfig) bool {
+	_, ok := cd.ValueType().(Referrer)
+	return ok
+}
+
+// validateRefColumns backlinks型のbaseがref型のカラムを指しているかを確かめる。
+func validateRefColumns(config *DustpanConfig) error {
+	for i := range config.ColumnDefs {
+		cd := &config.ColumnDefs[i]
+		if _, ok := cd.ValueType().(backlinksType); ok && len(cd.Base) > 0 {
+			if bc := config.GetColumnDef(cd.Base); bc == nil || !isRefColumn(bc) {
+				return ErrorInvalidBacklinksBase
+			}
+		}
+		if cd.Dependency && !isRefColumn(cd) {
+			return ErrorInvalidDependency
+		}
+	}
+	return nil
+}
+
+// linkDocs すべての文書の前処理の後で、backlinks型のセクションを作り、依存関係の循環を検出する。
+func linkDocs(config *DustpanConfig, index map[string]*dptxt.Document, docs []*dptxt.Document) {
+	for i := range config.ColumnDefs {
+		cd := &config.ColumnDefs[i]
+		if _, ok := cd.ValueType().(backlinksType); ok {
+			addBacklinks(config, cd, index, docs)
+		}
+	}
+	detectCycles(config, index, docs)
+}
+
+// addBacklinks cdという名前のbacklinks型のセクションを、参照されているそれぞれの文書に作る。文書に書かれた同じ名前のセクションは置き換える。
+func addBacklinks(config *DustpanConfig, cd *ColumnConfig, index map[string]*dptxt.Document, docs []*dptxt.Document) {
+	backlinks := make(map[*dptxt.Document][]string)
+	for _, doc := range docs {
+		src := docBasename(doc)
+		seen := make(map[*dptxt.Document]bool)
+		for j := range config.ColumnDefs {
+			rc := &config.ColumnDefs[j]
+			ref, ok := rc.ValueType().(Referrer)
+			if !ok || (len(cd.Base) > 0 && rc.Name != cd.Base) {
+				continue
+			}
+			for _, t := range ref.Refs(doc.Lookup(rc.Name)) {
+				target := index[t]
+				if target != nil && !seen[target] {
+					seen[target] = true
+					backlinks[target] = append(backlinks[target], src)
+				}
+			}
+		}
+	}
+	for _, doc := range docs {
+		delete(doc.Sections, cd.Name)
+		if names, ok := backlinks[doc]; ok {
+			sec := dptxt.NewTextSection(strings.Join(names, ", "))
+			sec.Data = &RefValue{names}
+			doc.Sections[cd.Name] = sec
+		}
+	}
+}
+
+// detectCycles dependencyを指定したref型のカラムの参照を依存関係とみなし、循環している文書のセクションにエラーを設定する。
+func detectCycles(config *DustpanConfig, index map[string]*dptxt.Document, docs []*dptxt.Document) {
+	deps := make([]*ColumnConfig, 0)
+	for i := range config.ColumnDefs {
+		if config.ColumnDefs[i].Dependency {
+			deps = append(deps, &config.ColumnDefs[i])
+		}
+	}
+	if len(deps) == 0 {
+		return
+	}
+	edges := func(doc *dptxt.Document) []*dptxt.Document {
+		targets := make([]*dptxt.Document, 0)
+		for _, cd := range deps {
+			for _, t := range SectionRefs(doc.Lookup(cd.Name)) {
+				if target := index[t]; target != nil {
+					targets = append(targets, target)
+				}
+			}
+		}
+		return targets
+	}
+
+	// Tarjanのアルゴリズムで強連結成分を求め、2つ以上の文書からなるものか、自分自身を参照するものを循環とする。
+	order := make(map[*dptxt.Document]int)
+	lowlink := make(map[*dptxt.Document]int)
+	onStack := make(map[*dptxt.Document]bool)
+	stack := make([]*dptxt.Document, 0)
+	var visit func(doc *dptxt.Document)
+	visit = func(doc *dptxt.Document) {
+		order[doc] = len(order)
+		lowlink[doc] = order[doc]
+		stack = append(stack, doc)
+		onStack[doc] = true
+		selfloop := false
+		for _, target := range edges(doc) {
+			if target == doc {
+				selfloop = true
+			}
+			if _, ok := order[target]; !ok {
+				visit(target)
+				if lowlink[target] < lowlink[doc] {
+					lowlink[doc] = lowlink[target]
+				}
+			} else if onStack[target] && order[target] < lowlink[doc] {
+				lowlink[doc] = order[target]
+			}
+		}
+		if lowlink[doc] != order[doc] {
+			return
+		}
+		i := len(stack) - 1
+		for stack[i] != doc {
+			i--
+		}
+		component := stack[i:]
+		stack = stack[:i]
+		for _, d := range component {
+			onStack[d] = false
+		}
+		if len(component) > 1 || selfloop {
+			cycle := make(map[string]bool)
+			for _, d := range component {
+				cycle[docBasename(d)] = true
+			}
+			for _, d := range component {
+				setCycleError(deps, d, cycle)
+			}
+		}
+	}
+	for _, doc := range docs {
+		if _, ok := order[doc]; !ok {
+			visit(doc)
+		}
+	}
+}
+
+// setCycleError docの依存関係のセクションのうち、cycleに含まれる文書を参照しているものに循環のエラーを設定する。
+func setCycleError(deps []*ColumnConfig, doc *dptxt.Document, cycle map[string]bool) {
+	for _, cd := range deps {
+		sec := doc.Lookup(cd.Name)
+		if sec == nil || sec.Error != nil {
+			continue
+		}
+		for _, t := range SectionRefs(sec) {
+			if cycle[t] {
+				sec.Error = NewValueError(doc.Filename, sec.Linenum, ErrorDependencyCycle)
+				log.Println(sec.Error)
+				break
+			}
+		}
+	}
+}
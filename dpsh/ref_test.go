@@ -0,0 +1,151 @@
+package dpsh
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// refTestConfig 依存関係を表わすdependsと、そうでないrelatedを参照するカラムの設定
+const refTestConfig = `{
+	"columns": [
+		{ "name": "title", "type": "text" },
+		{ "name": "depends", "type": "ref", "dependency": true },
+		{ "name": "related", "type": "ref" },
+		{ "name": "blocks", "type": "backlinks", "base": "depends" },
+		{ "name": "mentioned", "type": "backlinks" }
+	],
+	"html": { "display": ["depends"] }
+}`
+
+func TestRefColumn(t *testing.T) {
+	config := loadTestConfig(t, refTestConfig)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@title: a\n@depends: #b, c b\n",
+		"b.txt", "@title: b\n",
+		"c.txt", "@title: c\n@related: a\n",
+	)
+	if err := docs[0].Lookup("depends").Error; err != nil {
+		t.Fatal(err)
+	}
+	// 先頭の「#」を取り除き、重複は除く。
+	tests := []struct {
+		doc int
+		csv string
+	}{
+		{0, `"a","b, c","","","c"`},
+		{1, `"b","","","a","a"`},
+		{2, `"c","","a","a","a"`},
+	}
+	for _, tt := range tests {
+		if actual := csvRow(t, config, docs[tt.doc]); actual != tt.csv {
+			t.Error(tt.doc, actual)
+		}
+	}
+
+	html := writeString(t, func(w *bufio.Writer) error {
+		return htmlWriteSection(config, docs[0].Lookup("depends"), "depends", nil, w)
+	})
+	if html != `<div class="dp-c" data-section="depends"><div class="dp-refs"><a class="dp-ref" href="#b">#b</a><a class="dp-ref" href="#c">#c</a></div></div>` {
+		t.Error(html)
+	}
+	json := writeString(t, func(w *bufio.Writer) error {
+		return jsonWriteDocument(config, docs[0], w)
+	})
+	if json != `{"filename":"a.txt","sections":{"depends":["b","c"]} }` {
+		t.Error(json)
+	}
+
+	// 存在しない文書への参照は誤りにする。誤りの範囲は区切りの後ろの空白を除いた名前だけにする。
+	docs = loadTestDocs(t, config,
+		"a.txt", "@title: a\n@related: b,\n  #none\n",
+		"b.txt", "@title: b\n",
+	)
+	var ve *ValueError
+	if !errors.As(docs[0].Lookup("related").Error, &ve) || !errors.Is(ve, ErrorUnknownRef) || ve.Linenum != 2 {
+		t.Fatal(docs[0].Lookup("related").Error)
+	}
+	if ve.Span.Start.Line != 3 || ve.Span.Start.Column != 3 || ve.Span.End.Column != 8 {
+		t.Error(ve.Span)
+	}
+	// 存在する文書への参照は残し、書き出すときは書かれたとおりにする。
+	if refs := SectionRefs(docs[0].Lookup("related")); !reflect.DeepEqual(refs, []string{"b"}) {
+		t.Error(refs)
+	}
+	if actual := csvRow(t, config, docs[0]); actual != "\"a\",\"\",\"b,\n#none\",\"\",\"\"" {
+		t.Error(actual)
+	}
+	if actual := csvRow(t, config, docs[1]); actual != `"b","","","","a"` {
+		t.Error(actual)
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	config := loadTestConfig(t, refTestConfig)
+	src := `@depends: c
+@related: c
+@blocks: 書かれた値は使わない
+`
+	docs := loadTestDocs(t, config,
+		"a.txt", "@depends: c\n@related: b\n",
+		"b.txt", src,
+		"c.txt", "@title: c\n",
+	)
+	// baseを指定したカラムはdependsの参照だけを集め、省略したカラムはすべてのref型のカラムから集める。
+	tests := []struct {
+		doc       int
+		blocks    []string
+		mentioned []string
+	}{
+		{0, nil, nil},
+		{1, nil, []string{"a"}},
+		{2, []string{"a", "b"}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		doc := docs[tt.doc]
+		if refs := SectionRefs(doc.Lookup("blocks")); !reflect.DeepEqual(refs, tt.blocks) {
+			t.Errorf("%s blocks: %v", docBasename(doc), refs)
+		}
+		if refs := SectionRefs(doc.Lookup("mentioned")); !reflect.DeepEqual(refs, tt.mentioned) {
+			t.Errorf("%s mentioned: %v", docBasename(doc), refs)
+		}
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	config := loadTestConfig(t, refTestConfig)
+	docs := loadTestDocs(t, config,
+		// aとbは互いに依存し、cは自分自身に依存する。dはaに依存するが循環には含まれない。
+		"a.txt", "@depends: b\n",
+		"b.txt", "@depends: a\n",
+		"c.txt", "@depends: c\n",
+		"d.txt", "@depends: a\n@related: d\n",
+	)
+	for i, cycle := range []bool{true, true, true, false} {
+		if err := docs[i].Lookup("depends").Error; cycle != errors.Is(err, ErrorDependencyCycle) {
+			t.Errorf("%s: %v", docBasename(docs[i]), err)
+		}
+	}
+	// 依存関係でないカラムの自己参照は循環としない。
+	if err := docs[3].Lookup("related").Error; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateRefColumns(t *testing.T) {
+	tests := []struct {
+		cds []ColumnConfig
+		err error
+	}{
+		{[]ColumnConfig{{Name: "r", Type: "ref", Dependency: true}, {Name: "b", Type: "backlinks", Base: "r"}}, nil},
+		{[]ColumnConfig{{Name: "b", Type: "backlinks", Base: "none"}}, ErrorInvalidBacklinksBase},
+		{[]ColumnConfig{{Name: "t", Type: "text"}, {Name: "b", Type: "backlinks", Base: "t"}}, ErrorInvalidBacklinksBase},
+		{[]ColumnConfig{{Name: "b", Type: "backlinks", Dependency: true}}, ErrorInvalidDependency},
+	}
+	for i, tt := range tests {
+		if err := validateRefColumns(&DustpanConfig{ColumnDefs: tt.cds}); err != tt.err {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package dpsh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// baseDate 相対的な日付の基準日を返す。cd.Baseが指定されていればそのセクションの日付、
+// 指定されていなければ最初のlog型のカラムの最初のログの日付を基準日とする。
+func baseDate(config *DustpanConfig, cd *ColumnConfig, doc *dptxt.Document) (time.Time, bool) {
+	if len(cd.Base) > 0 {
+		sec := doc.Lookup(cd.Base)
+		if sec == nil {
+			return time.Time{}, false
+		}
+		if dv := SectionDate(sec); dv != nil {
+			return dv.Time, true
+		}
+		dt, post, err := dptxt.ParseDateTime(sec.PeekString())
+		if err != nil || len(post) > 0 {
+			return time.Time{}, false
+		}
+		return dt.Time(time.Local)
+	}
+
+	return logDate(config, doc, false)
+}
+
+// logDate 最初のlog型のカラムの最初のログの日付を返す。lastがtrueなら最後のログの日付を返す。
+func logDate(config *DustpanConfig, doc *dptxt.Document, last bool) (time.Time, bool) {
+	for _, lc := range config.ColumnDefs {
+		if _, ok := lc.ValueType().(logType); !ok {
+			continue
+		}
+		sec := doc.Lookup(lc.Name)
+		if sec == nil {
+			continue
+		}
+		paras := logParagraphs(sec)
+		for i := range paras {
+			p := paras[i]
+			if last {
+				p = paras[len(paras)-1-i]
+			}
+			if t, ok := logTime(p); ok {
+				return t, true
+			}
+		}
+		break
+	}
+	return time.Time{}, false
+}
+
+// logTime パラグラフのログの日付を返す。
+func logTime(p *dptxt.Paragraph) (time.Time, bool) {
+	// 前処理済みのログは、日付を取り除いた複製のTimeメンバを使う。
+	if p.Time != nil {
+		return *p.Time, true
+	}
+	if len(p.Value) > 0 && p.Kind == dptxt.TextParagraph {
+		dt, _, _, err := dptxt.ParseLogDateTime(p.Value[len(p.Value)-1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		return dt.Time(time.Local)
+	}
+	return time.Time{}, false
+}
+
+// relativeDate textを基準日からの相対的な日付として解析する。
+// 相対的な日付でなければdptxt.ErrorNotRelativeDateを返す。
+func relativeDate(config *DustpanConfig, cd *ColumnConfig, doc *dptxt.Document, text string) (dptxt.DateTime, string, error) {
+	base, ok := baseDate(config, cd, doc)
+	if !ok {
+		// 基準日がなくても、相対的な日付でなければそのことを返す。
+		_, _, err := dptxt.ParseRelativeDate(text, time.Now())
+		if err == nil {
+			err = ErrorNoBaseDate
+		}
+		return dptxt.DateTime{}, "", err
+	}
+	return dptxt.ParseRelativeDate(text, base)
+}
+
+// formatDateTime 日付をdptxtの日付の書式にする。
+func formatDateTime(dt *dptxt.DateTime) string {
+	t, _ := dt.Time(time.Local)
+	layout := "2006-01-02"
+	if dt.HasClock {
+		layout += " 15:04"
+		if dt.Second != 0 {
+			layout += ":05"
+		}
+	}
+	return t.Format(layout)
+}
+
+// ResolveRelativeDates 設定に基づいて対象となるすべてのファイルを読み込み、deadline型のカラムの相対的な日付を
+// 基準日から求めた日付に書き換える。書き換えたセクション以外は元のファイルのままになる。
+func ResolveRelativeDates(basepath string, config *DustpanConfig) error {
+	for _, doc := range LoadAllFilesWithConfig(basepath, config) {
+		modified := false
+		for _, cd := range config.ColumnDefs {
+			if dt, ok := cd.ValueType().(dateType); !ok || !dt.deadline {
+				continue
+			}
+			c := doc.Lookup(cd.Name)
+			if c == nil || len(c.Value) != 1 || len(c.Value[0].Value) != 1 {
+				continue
+			}
+			dt, post, err := relativeDate(config, &cd, doc, c.PeekString())
+			if err == dptxt.ErrorNotRelativeDate {
+				continue
+			}
+			if err == nil && len(post) > 0 {
+				err = ErrorMultipleValue
+			}
+			if err != nil {
+				log.Println(NewValueError(doc.Filename, c.Linenum, err))
+				continue
+			}
+			c.Value[0].Value[0] = formatDateTime(&dt)
+			modified = true
+		}
+		if modified {
+			if err := rewriteFile(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteFile 文書を一時ファイルに書き出してから、元のファイルと置き換える。元のファイルにBOMがあればBOMも書き出す。
+// 名前の変更で置き換えられるように一時ファイルは元のファイルと同じディレクトリに作り、元のファイルのパーミッションを引き継ぐ。
+func rewriteFile(doc *dptxt.Document) error {
+	fi, err := os.Stat(doc.Filename)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(doc.Filename)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(doc.Filename), fmt.Sprintf(tempfileTemplate, "dptxt"))
+	if err != nil {
+		return err
+	}
+	// ファイルの後始末
+	defer func() {
+		if err != nil {
+			os.Remove(tmpfile.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmpfile)
+	if bytes.HasPrefix(src, utf8BOM) {
+		_, err = w.Write(utf8BOM)
+	}
+	if err == nil {
+		err = dptxt.WriteDocument(w, doc)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmpfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpfile.Name(), fi.Mode())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpfile.Name(), doc.Filename)
+	if err != nil {
+		return err
+	}
+	log.Println(doc.Filename, "相対的な日付を書き換えました。")
+	return nil
+}
@@ -0,0 +1,86 @@
+package dpsh
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// compileSchema カラムの定義の制約を検証し、値と比べられる形にしてcdに格納する。
+func compileSchema(config *DustpanConfig, cd *ColumnConfig) error {
+	if cd.MinParagraphs < 0 || cd.MaxParagraphs < 0 || (cd.MaxParagraphs > 0 && cd.MinParagraphs > cd.MaxParagraphs) {
+		return ErrorInvalidParagraphCount
+	}
+	if len(cd.Pattern) > 0 {
+		re, err := regexp.Compile(`^(?:` + cd.Pattern + `)$`)
+		if err != nil {
+			return err
+		}
+		cd.pattern = re
+	}
+	var err error
+	if cd.min, err = parseBound(config, cd, cd.Min); err != nil {
+		return err
+	}
+	if cd.max, err = parseBound(config, cd, cd.Max); err != nil {
+		return err
+	}
+	if cd.min != nil && cd.max != nil && cd.ValueType().Compare(cd.min, cd.max) > 0 {
+		return ErrorInvalidBound
+	}
+	return nil
+}
+
+// parseBound min、maxに指定された値を、カラムの型で解析したセクションにする。
+func parseBound(config *DustpanConfig, cd *ColumnConfig, bound string) (*dptxt.Section, error) {
+	if len(bound) == 0 {
+		return nil, nil
+	}
+	sec := dptxt.NewTextSection(bound)
+	ctx := &PreprocessContext{Config: config, Column: cd, Doc: &dptxt.Document{Filename: cd.Name}, Now: time.Now()}
+	if err := cd.ValueType().Preprocess(ctx, sec); err != nil {
+		return nil, ErrorInvalidBound
+	}
+	return sec, nil
+}
+
+// checkSchema カラムの定義の制約をセクションが満たしているかを確かめる。文書にセクションがなければsecはnilになる。
+func checkSchema(ctx *PreprocessContext, sec *dptxt.Section) error {
+	cd := ctx.Column
+	if sec == nil || len(sec.Value) == 0 {
+		if !cd.Required {
+			return nil
+		}
+		linenum := -1
+		if sec != nil {
+			linenum = sec.Linenum
+		}
+		return NewValueError(ctx.Doc.Filename, linenum, ErrorRequiredSection)
+	}
+	if cd.MinParagraphs > 0 && len(sec.Value) < cd.MinParagraphs {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, ErrorTooFewParagraphs)
+	}
+	if cd.MaxParagraphs > 0 && len(sec.Value) > cd.MaxParagraphs {
+		return NewValueError(ctx.Doc.Filename, sec.Value[cd.MaxParagraphs].Linenum, ErrorTooManyParagraphs)
+	}
+	if cd.pattern != nil {
+		for _, p := range sec.Value {
+			if p.Kind != dptxt.TextParagraph {
+				continue
+			}
+			if !cd.pattern.MatchString(strings.Join(p.Value, "\n")) {
+				return NewValueError(ctx.Doc.Filename, p.Linenum, ErrorPatternMismatch)
+			}
+		}
+	}
+	vt := cd.ValueType()
+	if cd.min != nil && vt.Compare(sec, cd.min) < 0 {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, ErrorBelowMin)
+	}
+	if cd.max != nil && vt.Compare(sec, cd.max) > 0 {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, ErrorAboveMax)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package dpsh
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestCompileSchema(t *testing.T) {
+	tests := []struct {
+		src string
+		err error
+	}{
+		{`{ "name": "v", "type": "number", "min": "1", "max": "10", "minparagraphs": 1, "maxparagraphs": 1 }`, nil},
+		{`{ "name": "v", "type": "date", "min": "2020/1/1", "max": "2020/1/1" }`, nil},
+		{`{ "name": "v", "type": "text", "minparagraphs": -1 }`, ErrorInvalidParagraphCount},
+		{`{ "name": "v", "type": "text", "minparagraphs": 3, "maxparagraphs": 2 }`, ErrorInvalidParagraphCount},
+		{`{ "name": "v", "type": "number", "min": "x" }`, ErrorInvalidBound},
+		{`{ "name": "v", "type": "date", "max": "2020/2/30" }`, ErrorInvalidBound},
+		{`{ "name": "v", "type": "number", "min": "10", "max": "1" }`, ErrorInvalidBound},
+	}
+	for _, tt := range tests {
+		config := new(DustpanConfig)
+		config.ColumnDefs = make([]ColumnConfig, 1)
+		if err := json.Unmarshal([]byte(tt.src), &config.ColumnDefs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := compileSchema(config, &config.ColumnDefs[0]); err != tt.err {
+			t.Errorf("%s: %v", tt.src, err)
+		}
+	}
+	cd := ColumnConfig{Name: "v", Type: "text", Pattern: "("}
+	if err := compileSchema(&DustpanConfig{}, &cd); err == nil {
+		t.Error("pattern")
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text", "required": true },
+			{ "name": "id", "type": "text", "pattern": "[A-Z]+-[0-9]+" },
+			{ "name": "priority", "type": "number", "min": "1", "max": "5" },
+			{ "name": "due", "type": "date", "min": "2020/1/1" },
+			{ "name": "log", "type": "log", "minparagraphs": 2, "maxparagraphs": 3 }
+		]
+	}`)
+	tests := []struct {
+		src     string
+		section string
+		err     error
+		linenum int
+	}{
+		{"@title: t\n@id: DP-1\n@priority: 5\n@due: 2020/1/1\n@log: a(2020/1/1)\n\nb(2020/1/2)\n", "", nil, 0},
+		{"@id: DP-1\n", "title", ErrorRequiredSection, -1},
+		{"@title:\n", "title", ErrorRequiredSection, 1},
+		// パターンはそれぞれのパラグラフ全体と比べ、コードブロックとは比べない。
+		{"@title: t\n@id: DP-1x\n", "id", ErrorPatternMismatch, 2},
+		{"@title: t\n@id: DP-1\n\nxx\n", "id", ErrorPatternMismatch, 4},
+		{"@title: t\n@id: DP-1\n\n```\nxx\n```\n", "", nil, 0},
+		{"@title: t\n@priority: 0\n", "priority", ErrorBelowMin, 2},
+		{"@title: t\n@priority: 6\n", "priority", ErrorAboveMax, 2},
+		{"@title: t\n@due: 2019/12/31\n", "due", ErrorBelowMin, 2},
+		{"@title: t\n@log: a(2020/1/1)\n", "log", ErrorTooFewParagraphs, 2},
+		{"@title: t\n@log: a(2020/1/1)\n\nb(2020/1/2)\n\nc(2020/1/3)\n\nd(2020/1/4)\n", "log", ErrorTooManyParagraphs, 8},
+		// 値の誤りは制約より先に報告する。
+		{"@title: t\n@priority: x\n", "priority", strconv.ErrSyntax, 2},
+	}
+	for _, tt := range tests {
+		doc := loadTestDocs(t, config, "a.txt", tt.src)[0]
+		for i := range config.ColumnDefs {
+			name := config.ColumnDefs[i].Name
+			sec := doc.Lookup(name)
+			if name != tt.section {
+				if sec != nil && sec.Error != nil {
+					t.Errorf("%q %s: %v", tt.src, name, sec.Error)
+				}
+				continue
+			}
+			var ve *ValueError
+			if sec == nil || !errors.As(sec.Error, &ve) || !errors.Is(ve, tt.err) || ve.Linenum != tt.linenum {
+				t.Errorf("%q %s: %+v", tt.src, name, sec)
+			}
+		}
+	}
+
+	// 行番号が分からなければ、ファイル名だけを付ける。
+	doc := loadTestDocs(t, config, "a.txt", "@id: DP-1\n")[0]
+	if err := doc.Lookup("title").Error; err == nil || err.Error() != "a.txt 必須のセクションがない" {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,157 @@
+package dpsh
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+var divTagsOpen []byte = []byte(`<div class="dp-tags">`)
+var divTagsClose []byte = []byte("</div>")
+var spanTagFmt string = `<span class="dp-tag" data-tag="%v">%v</span>`
+
+// isTagSeparator タグの区切りの文字かどうか
+func isTagSeparator(r rune) bool {
+	return r == ',' || r == '，' || r == '、' || unicode.IsSpace(r)
+}
+
+// ParseTags 値をカンマ、全角のカンマ、読点、空白で区切ってタグの集合にする。重複したタグは最初のものだけを残す。
+func ParseTags(v string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range strings.FieldsFunc(v, isTagSeparator) {
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// fieldSpans lineをsepで区切り、前後の空白を除いた空でない部分の範囲を返す。範囲は[begin, end)のバイト位置の組。
+func fieldSpans(line string, sep func(rune) bool) [][2]int {
+	spans := make([][2]int, 0)
+	begin := 0
+	for {
+		end := strings.IndexFunc(line[begin:], sep)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += begin
+		}
+		b := begin + len(line[begin:end]) - len(strings.TrimLeftFunc(line[begin:end], unicode.IsSpace))
+		e := begin + len(strings.TrimRightFunc(line[begin:end], unicode.IsSpace))
+		if b < e {
+			spans = append(spans, [2]int{b, e})
+		}
+		if end == len(line) {
+			return spans
+		}
+		_, s := utf8.DecodeRuneInString(line[end:])
+		begin = end + s
+	}
+}
+
+// SectionTags セクションのタグを書かれた順に返す。tags型の値でなければnilを返す。
+func SectionTags(sec *dptxt.Section) []string {
+	if sec == nil {
+		return nil
+	}
+	tags, _ := sec.Data.([]string)
+	return tags
+}
+
+// tagsType tags型。sec.Dataには[]stringを格納する。
+type tagsType struct {
+	TextType
+}
+
+func (tagsType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil {
+		return nil
+	}
+	// すべてのパラグラフのすべての行からタグを集める。コードブロックは対象外。
+	lines := make([]string, 0)
+	for _, p := range sec.Value {
+		if p.Kind == dptxt.TextParagraph {
+			lines = append(lines, p.Value...)
+		}
+	}
+	sec.Data = ParseTags(strings.Join(lines, "\n"))
+	return nil
+}
+
+// sortedTags 比較のために並べ替えたタグを返す。
+func sortedTags(sec *dptxt.Section) []string {
+	tags := append([]string(nil), SectionTags(sec)...)
+	sort.Strings(tags)
+	return tags
+}
+
+// Compare タグを辞書順に並べたものを先頭から比べる。タグがないものが最初になる。
+func (tagsType) Compare(a, b *dptxt.Section) int {
+	at := sortedTags(a)
+	bt := sortedTags(b)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if r := strings.Compare(at[i], bt[i]); r != 0 {
+			return r
+		}
+	}
+	return len(at) - len(bt)
+}
+
+func (tagsType) Keys(sec *dptxt.Section) []string {
+	return SectionTags(sec)
+}
+
+func (tagsType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	tags := SectionTags(sec)
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := w.Write(divTagsOpen)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		et := html.EscapeString(t)
+		_, err = w.WriteString(fmt.Sprintf(spanTagFmt, et, et))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(divTagsClose)
+	return err
+}
+
+func (tagsType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	_, err := w.WriteString(csvEscapeString(strings.Join(SectionTags(sec), ", ")))
+	return err
+}
+
+func (tagsType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	_, err := w.WriteString(`[`)
+	if err != nil {
+		return err
+	}
+	sep := sepEmpty
+	for _, t := range SectionTags(sec) {
+		_, err = w.Write(sep)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString(`"` + jsonEscapeString(t) + `"`)
+		if err != nil {
+			return err
+		}
+		sep = sepComma
+	}
+	_, err = w.WriteString(`]`)
+	return err
+}
@@ -0,0 +1,178 @@
+package dpsh
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		v    string
+		tags []string
+	}{
+		{"ui, crash, 緊急", []string{"ui", "crash", "緊急"}},
+		{"ui，crash、緊急", []string{"ui", "crash", "緊急"}},
+		{"ui crash\nui", []string{"ui", "crash"}},
+		{" , ", []string{}},
+	}
+	for _, tt := range tests {
+		if tags := ParseTags(tt.v); !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("%q: %q", tt.v, tags)
+		}
+	}
+}
+
+func TestTagsFilter(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text" },
+			{ "name": "labels", "type": "tags" }
+		],
+		"filter": [{ "name": "labels", "values": ["CRASH", "緊急"] }]
+	}`)
+	src := `@title: a
+@labels: ui, crash
+
+` + "```" + `
+x
+` + "```" + `
+`
+	docs := loadTestDocs(t, config,
+		"a.txt", src,
+		"b.txt", "@title: b\n@labels: ui\n",
+		"c.txt", "@title: c\n@labels: 緊急\n",
+		"d.txt", "@title: d\n",
+	)
+	// コードブロックはタグにしない。
+	expected := []string{`"a","ui, crash"`, `"c","緊急"`}
+	filtered := FilterDocs(config, docs)
+	if len(filtered) != len(expected) {
+		t.Fatal(len(filtered))
+	}
+	for i, doc := range filtered {
+		if actual := csvRow(t, config, doc); actual != expected[i] {
+			t.Error(i, actual)
+		}
+	}
+
+	// タグを並べ替えて先頭から比べ、タグがないものを最初にする。
+	vt := config.ColumnDefs[1].ValueType()
+	if vt.Compare(docs[0].Lookup("labels"), docs[1].Lookup("labels")) >= 0 ||
+		vt.Compare(docs[3].Lookup("labels"), docs[1].Lookup("labels")) >= 0 {
+		t.Error("Compare")
+	}
+}
+
+func TestTagsOutput(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [{ "name": "labels", "type": "tags" }],
+		"html": { "display": ["labels"] }
+	}`)
+	docs := loadTestDocs(t, config, "a.txt", "@labels: <b>, ui\n", "b.txt", "@title: なし\n")
+	tests := []struct {
+		doc  int
+		html string
+		json string
+	}{
+		{
+			0,
+			`<div class="dp-c" data-section="labels"><div class="dp-tags">` +
+				`<span class="dp-tag" data-tag="&lt;b&gt;">&lt;b&gt;</span><span class="dp-tag" data-tag="ui">ui</span></div></div>`,
+			`{"filename":"a.txt","sections":{"labels":["<b>","ui"]} }`,
+		},
+		{
+			1,
+			`<div class="dp-c" data-section="labels"></div>`,
+			`{"filename":"b.txt","sections":{"labels":{}} }`,
+		},
+	}
+	for _, tt := range tests {
+		doc := docs[tt.doc]
+		html := writeString(t, func(w *bufio.Writer) error {
+			return htmlWriteSection(config, doc.Lookup("labels"), "labels", nil, w)
+		})
+		if html != tt.html {
+			t.Error(tt.doc, html)
+		}
+		json := writeString(t, func(w *bufio.Writer) error {
+			return jsonWriteDocument(config, doc, w)
+		})
+		if json != tt.json {
+			t.Error(tt.doc, json)
+		}
+	}
+}
+
+func TestGroupDocs(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "labels", "type": "tags" },
+			{ "name": "status", "type": "text" }
+		]
+	}`)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@labels: ui, crash\n@status: open\n",
+		"b.txt", "@status: closed\n",
+		"c.txt", "@labels: crash\n@status: open\n",
+	)
+	// 組は最初に現われた順に並べ、キーのない文書の組は最後にする。
+	tests := []struct {
+		name   string
+		groups []string
+	}{
+		{"labels", []string{"ui:a", "crash:ac", ":b"}},
+		{"status", []string{"open:ac", "closed:b"}},
+	}
+	for _, tt := range tests {
+		groups := GroupDocs(config, tt.name, docs)
+		actual := make([]string, len(groups))
+		for i, g := range groups {
+			actual[i] = g.Key + ":"
+			for _, d := range g.Docs {
+				actual[i] += docBasename(d)
+			}
+		}
+		if !reflect.DeepEqual(actual, tt.groups) {
+			t.Error(tt.name, actual)
+		}
+	}
+}
+
+func TestGroupedHTML(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text" },
+			{ "name": "labels", "type": "tags" }
+		],
+		"html": { "display": ["title", "labels"], "group": "labels" }
+	}`)
+	docs := loadTestDocs(t, config,
+		"a.txt", "@title: a\n@labels: ui, crash\n",
+		"b.txt", "@title: b\n",
+		"c.txt", "@title: c\n@labels: crash\n",
+	)
+	var buf bytes.Buffer
+	if err := WriteHTMLTo(&buf, ".", config, docs); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	// 複数の組に現われる文書にも、idは最初の行にだけ付ける。
+	tests := []struct {
+		s     string
+		count int
+	}{
+		{`<div class="dp-r" id="a" data-filename="a.txt">`, 1},
+		{`<div class="dp-r" data-filename="a.txt">`, 1},
+		{`id="b"`, 1},
+		{`id="c"`, 1},
+		{`<div class="dp-r dp-group" data-group="crash">`, 1},
+	}
+	for _, tt := range tests {
+		if n := strings.Count(html, tt.s); n != tt.count {
+			t.Errorf("%s: %d", tt.s, n)
+		}
+	}
+}
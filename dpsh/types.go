@@ -0,0 +1,525 @@
+package dpsh
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// ValueType カラムの型。セクションの値の解析と検証、並べ替えのための比較、各形式への出力を定義する。
+// 独自の型を作る場合は、TextTypeを埋め込んで必要なメソッドだけを定義するとよい。
+type ValueType interface {
+	// Preprocess セクションの値を解析して検証する。解析した値はsec.Dataに格納し、値の誤りはエラーとして返す。
+	// 文書にセクションがなければsecはnilになる。
+	Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error
+	// Compare 並べ替えのために2つのセクションを比較し、aが前なら負、bが前なら正、同じなら0を返す。
+	// 文書にセクションがなければnilになる。
+	Compare(a, b *dptxt.Section) int
+	// WriteHTML セクションの値をHTMLのセルの中身として書き出す。値のエラーは呼び出し側が書き出す。
+	WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error
+	// WriteCsv セクションの値をCSVのダブルクォートで囲んだフィールドの中身として書き出す。
+	WriteCsv(sec *dptxt.Section, w *bufio.Writer) error
+	// WriteJSON セクションの値をJSONの値として書き出す。値のエラーも書き出す。
+	WriteJSON(sec *dptxt.Section, w *bufio.Writer) error
+}
+
+// ColumnValidator カラムの定義を検証するValueType。ValueTypeがこのインターフェースも実装していれば、
+// 設定ファイルを読み込むときにカラムの定義ごとに呼び出す。
+type ColumnValidator interface {
+	ValidateColumn(cd *ColumnConfig) error
+}
+
+// Totaler セクションの値を合計できるValueType。合計の行を出力する設定のとき、このインターフェースを実装した型のカラムに合計を出力する。
+type Totaler interface {
+	// Total セクションの値の合計を文字列にして返す。文書にセクションがなければnilになる。
+	Total(secs []*dptxt.Section) string
+}
+
+// PreprocessContext 前処理でValueTypeに渡す情報
+type PreprocessContext struct {
+	Config *DustpanConfig
+	Column *ColumnConfig
+	Doc    *dptxt.Document
+	Now    time.Time // 前処理を始めた日時
+	// すべての文書。ファイル名から拡張子を除いたものをキーとする。
+	Docs map[string]*dptxt.Document
+}
+
+var valueTypes = map[string]ValueType{
+	ColumnTypeText:      TextType{},
+	ColumnTypeNumber:    numberType{},
+	ColumnTypeDate:      dateType{},
+	ColumnTypeDatetime:  dateType{clock: true},
+	ColumnTypeDeadline:  dateType{clock: true, deadline: true},
+	ColumnTypeLog:       logType{},
+	ColumnTypeFilename:  filenameType{},
+	ColumnTypeChecklist: checklistType{},
+	ColumnTypeEnum:      enumType{},
+	ColumnTypeTags:      tagsType{},
+	ColumnTypeDecimal:   decimalType{},
+	ColumnTypeEstimate:  estimateType{},
+	ColumnTypePerson:    personType{},
+	ColumnTypeRef:       refType{},
+	ColumnTypeBacklinks: backlinksType{},
+}
+
+// RegisterType nameという名前でカラムの型を登録する。同じ名前の型があれば置き換える。
+// 設定ファイルを読み込む前に登録する必要がある。
+func RegisterType(name string, vt ValueType) {
+	valueTypes[strings.ToLower(name)] = vt
+}
+
+// LookupType nameという名前のカラムの型を返す。名前の大文字と小文字は区別しない。
+func LookupType(name string) (ValueType, bool) {
+	vt, ok := valueTypes[strings.ToLower(name)]
+	return vt, ok
+}
+
+// ValueType カラムの型を返す。未知の型ならtext型として扱う。
+func (cd *ColumnConfig) ValueType() ValueType {
+	if vt, ok := LookupType(cd.Type); ok {
+		return vt
+	}
+	return TextType{}
+}
+
+// columnType nameという名前のカラムの型を返す。定義されていないカラムはtext型として扱う。
+func (config *DustpanConfig) columnType(name string) ValueType {
+	if cd := config.GetColumnDef(name); cd != nil {
+		return cd.ValueType()
+	}
+	return TextType{}
+}
+
+// columnTotals namesのカラムごとに、docsのセクションの値の合計を返す。合計できない型のカラムは空文字列にする。
+func (config *DustpanConfig) columnTotals(names []string, docs []*dptxt.Document) []string {
+	totals := make([]string, len(names))
+	for i, name := range names {
+		t, ok := config.columnType(name).(Totaler)
+		if !ok {
+			continue
+		}
+		secs := make([]*dptxt.Section, len(docs))
+		for j, doc := range docs {
+			secs[j] = doc.Lookup(name)
+		}
+		totals[i] = t.Total(secs)
+	}
+	return totals
+}
+
+// TextType text型。値を解析せずにそのまま出力する。
+type TextType struct{}
+
+// Preprocess 何もしない。
+func (TextType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	return nil
+}
+
+// Compare 最初の値を文字列として比較する。セクションがなければ空文字列として扱う。
+func (TextType) Compare(a, b *dptxt.Section) int {
+	// 対応するセクションがなければ空文字列として扱う。
+	av := ""
+	bv := ""
+	if a != nil {
+		av = a.PeekString()
+	}
+	if b != nil {
+		bv = b.PeekString()
+	}
+	return strings.Compare(av, bv)
+}
+
+// WriteHTML すべてのパラグラフを書き出す。
+func (TextType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	for _, p := range sec.Value {
+		err := htmlWriteParagraph(p, config.HTML.Era, w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCsv すべてのパラグラフを空白行で区切って書き出す。
+func (TextType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	sep := sepEmpty
+	for _, p := range sec.Value {
+		_, err := w.Write(sep)
+		if err != nil {
+			return err
+		}
+		err = csvWriteParagraph(p, w)
+		if err != nil {
+			return err
+		}
+		sep = sep2Newline
+	}
+	return nil
+}
+
+// WriteJSON 一行だけの値は文字列として、それ以外はパラグラフの配列を持つオブジェクトとして書き出す。
+func (TextType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	if len(sec.Value) == 1 && len(sec.Value[0].Value) == 1 && sec.Value[0].Kind == dptxt.TextParagraph && sec.Error == nil {
+		_, err := w.WriteString(fmt.Sprintf(`"%v"`, jsonEscapeString(dptxt.PlainText(dptxt.ParseInline(sec.Value[0].Value[0])))))
+		return err
+	}
+	return jsonWriteObject(sec, w, jsonWriteValues)
+}
+
+// filenameType filename型。セクションがなければ拡張子を除いたファイル名を値とする。
+type filenameType struct {
+	TextType
+}
+
+func (filenameType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil {
+		ctx.Doc.Sections[ctx.Column.Name] = dptxt.NewTextSection(docBasename(ctx.Doc))
+	}
+	return nil
+}
+
+// singleValue セクションの値が一つだけかを確かめる。
+func singleValue(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if len(sec.Value) >= 2 || (len(sec.Value) == 1 && len(sec.Value[0].Value) > 1) {
+		return NewValueError(ctx.Doc.Filename, sec.Value[0].Linenum, ErrorMultipleValue)
+	}
+	return nil
+}
+
+// numberType number型。値は符号付き整数で、sec.Dataにはint64を格納する。
+type numberType struct {
+	TextType
+}
+
+func (numberType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil || len(sec.Value) == 0 {
+		return nil
+	}
+	if err := singleValue(ctx, sec); err != nil {
+		return err
+	}
+	num, err := strconv.ParseInt(sec.PeekString(), 10, 64)
+	if err != nil {
+		return NewValueError(ctx.Doc.Filename, sec.Linenum, err)
+	}
+	sec.Data = num
+	sec.Number = num
+	return nil
+}
+
+func sectionNumber(sec *dptxt.Section) int64 {
+	if sec != nil {
+		if n, ok := sec.Data.(int64); ok {
+			return n
+		}
+	}
+	return 0
+}
+
+func (numberType) Compare(a, b *dptxt.Section) int {
+	av := sectionNumber(a)
+	bv := sectionNumber(b)
+	switch {
+	case av < bv:
+		return -1
+	case av > bv:
+		return 1
+	}
+	return 0
+}
+
+// Total 値の和を返す。和が範囲を超える場合は空文字列を返す。
+func (numberType) Total(secs []*dptxt.Section) string {
+	var total int64
+	for _, sec := range secs {
+		n := sectionNumber(sec)
+		if (n > 0 && total > math.MaxInt64-n) || (n < 0 && total < math.MinInt64-n) {
+			log.Println(dptxt.ErrorNumberOutOfRange)
+			return ""
+		}
+		total += n
+	}
+	return strconv.FormatInt(total, 10)
+}
+
+// DateValue date、datetime、deadline型のセクションの値
+type DateValue struct {
+	Time     time.Time
+	HasClock bool // 時刻が指定されているか
+	Expired  bool // deadline型で、現在日時より前か
+	Relative bool // 相対的な日付から求めたものか
+}
+
+// SectionDate セクションの日付を返す。日付の値でなければnilを返す。
+func SectionDate(sec *dptxt.Section) *DateValue {
+	if sec == nil {
+		return nil
+	}
+	dv, _ := sec.Data.(*DateValue)
+	return dv
+}
+
+// dateType date、datetime、deadline型。sec.Dataには*DateValueを格納する。
+type dateType struct {
+	TextType
+	clock    bool // 時刻を書けるか
+	deadline bool // 有効期限か
+}
+
+func (dtype dateType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil || len(sec.Value) == 0 {
+		return nil
+	}
+	if err := singleValue(ctx, sec); err != nil {
+		return err
+	}
+	var (
+		dt         dptxt.DateTime
+		post       string
+		begin, end int
+		err        error
+		relative   bool
+	)
+	pb := sec.PeekString()
+	if !dtype.clock {
+		dt.Year, dt.Month, dt.Day, post, begin, end, err = dptxt.ParseDateRange(pb)
+	} else {
+		dt, post, begin, end, err = dptxt.ParseDateTimeRange(pb)
+		if err != nil && dtype.deadline {
+			// 日付として読めなければ、相対的な日付として読む。
+			if rdt, rpost, rerr := relativeDate(ctx.Config, ctx.Column, ctx.Doc, pb); rerr != dptxt.ErrorNotRelativeDate {
+				dt, post, begin, end, err = rdt, rpost, 0, len(pb), rerr
+				relative = rerr == nil
+			}
+		}
+	}
+	span := sec.Value[0].SpanOf(0, begin, end)
+	if err != nil {
+		return NewValueErrorAt(ctx.Doc.Filename, sec.Linenum, span, err)
+	} else if len(post) > 0 {
+		return NewValueError(ctx.Doc.Filename, sec.Value[0].Linenum, ErrorMultipleValue)
+	}
+	// 日付の妥当性をチェックする。
+	t, ok := dt.Time(time.Local)
+	if !ok {
+		return NewValueErrorAt(ctx.Doc.Filename, sec.Linenum, span, ErrorInvalidDate)
+	}
+	// 有効期限型で値が現在日時より前なら有効期限切れのフラグを立てる。
+	dv := &DateValue{Time: t, HasClock: dt.HasClock, Expired: dtype.deadline && t.Before(ctx.Now), Relative: relative}
+	sec.Data = dv
+	sec.Time, sec.Expired = &dv.Time, dv.Expired
+	return nil
+}
+
+func (dateType) Compare(a, b *dptxt.Section) int {
+	// 日付がなければゼロ値の日時として扱う。
+	var at, bt time.Time
+	if dv := SectionDate(a); dv != nil {
+		at = dv.Time
+	}
+	if dv := SectionDate(b); dv != nil {
+		bt = dv.Time
+	}
+	switch {
+	case at.Before(bt):
+		return -1
+	case at.After(bt):
+		return 1
+	}
+	return 0
+}
+
+func (dtype dateType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	dv := SectionDate(sec)
+	if dv == nil {
+		return dtype.TextType.WriteHTML(config, sec, w)
+	}
+	attr, text := htmlDate(&dv.Time, dv.HasClock, config.HTML.Era)
+	f := divSecDateFmt
+	if dv.Expired {
+		f = divSecDateExpiredFmt
+	}
+	_, err := w.WriteString(fmt.Sprintf(f, attr, text))
+	return err
+}
+
+func (dtype dateType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	dv := SectionDate(sec)
+	if dv == nil {
+		return dtype.TextType.WriteJSON(sec, w)
+	}
+	return jsonWriteObject(sec, w, func(sec *dptxt.Section, w *bufio.Writer) error {
+		f := jsonSecDateFmt
+		if dv.Expired {
+			f = jsonSecDateExpiredFmt
+		}
+		_, err := w.WriteString(fmt.Sprintf(f, jsonDateFields(&dv.Time, dv.HasClock)))
+		return err
+	})
+}
+
+// LogValue log型のセクションの値
+type LogValue struct {
+	// sec.Valueのパラグラフごとに、末尾の日付を取り除いてTime、HasClock、TimeSuffixを設定した複製。
+	// 日付のないパラグラフは元のパラグラフのままにする。
+	Paragraphs []*dptxt.Paragraph
+}
+
+// logParagraphs ログのパラグラフを返す。前処理済みのセクションなら日付を取り除いた複製を返す。
+func logParagraphs(sec *dptxt.Section) []*dptxt.Paragraph {
+	if lv, ok := sec.Data.(*LogValue); ok {
+		return lv.Paragraphs
+	}
+	return sec.Value
+}
+
+// logSection 出力のために、パラグラフを日付を取り除いた複製に置き換えたセクションを返す。
+func logSection(sec *dptxt.Section) *dptxt.Section {
+	if _, ok := sec.Data.(*LogValue); !ok {
+		return sec
+	}
+	ls := *sec
+	ls.Value = logParagraphs(sec)
+	return &ls
+}
+
+// logType log型。パラグラフごとに末尾の日付を解析する。書き戻せるように文書のパラグラフは変更せずに、
+// 日付を取り除いた複製を作ってsec.Dataに*LogValueとして格納する。
+type logType struct {
+	TextType
+}
+
+func (logType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec == nil {
+		return nil
+	}
+	paras := make([]*dptxt.Paragraph, len(sec.Value))
+	for i, p := range sec.Value {
+		paras[i] = p
+		// コードブロックは直前のログの添付として扱い、日付を要求しない。
+		if len(p.Value) > 0 && p.Kind == dptxt.TextParagraph {
+			last := len(p.Value) - 1
+			dt, pre, post, begin, end, err := dptxt.ParseLogDateTimeRange(p.Value[last])
+			span := p.SpanOf(last, begin, end)
+			if err != nil {
+				p.Error = NewValueErrorAt(ctx.Doc.Filename, p.LineOf(last), span, err)
+			} else if t, ok := dt.Time(time.Local); ok {
+				lp := *p
+				lp.Value = append(make([]string, 0, len(p.Value)), p.Value...)
+				lp.Value[last] = pre
+				lp.Time = &t
+				lp.HasClock = dt.HasClock
+				lp.TimeSuffix = post
+				paras[i] = &lp
+			} else {
+				p.Error = NewValueErrorAt(ctx.Doc.Filename, p.LineOf(last), span, ErrorInvalidDate)
+			}
+		}
+		if p.Error != nil {
+			log.Println(p.Error)
+		}
+	}
+	sec.Data = &LogValue{paras}
+	return nil
+}
+
+// Compare 最初のパラグラフの最初の行を、日付を取り除いて比べる。
+func (logType) Compare(a, b *dptxt.Section) int {
+	return strings.Compare(logPeekString(a), logPeekString(b))
+}
+
+// logPeekString 日付を取り除いたパラグラフの最初の行を返す。対応するセクションがなければ空文字列を返す。
+func logPeekString(sec *dptxt.Section) string {
+	if sec == nil {
+		return ""
+	}
+	paras := logParagraphs(sec)
+	if len(paras) == 0 || len(paras[0].Value) == 0 {
+		return ""
+	}
+	return paras[0].Value[0]
+}
+
+func (ltype logType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	return ltype.TextType.WriteHTML(config, logSection(sec), w)
+}
+
+func (ltype logType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	return ltype.TextType.WriteCsv(logSection(sec), w)
+}
+
+func (ltype logType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	return ltype.TextType.WriteJSON(logSection(sec), w)
+}
+
+// checklistType checklist型。sec.Dataには*dptxt.Checklistを格納する。
+type checklistType struct {
+	TextType
+}
+
+func (checklistType) Preprocess(ctx *PreprocessContext, sec *dptxt.Section) error {
+	if sec != nil {
+		cl := sec.CountChecklist()
+		sec.Data = &cl
+	}
+	return nil
+}
+
+// sectionChecklist セクションのチェックリストの進捗を返す。チェックリストの値でなければnilを返す。
+func sectionChecklist(sec *dptxt.Section) *dptxt.Checklist {
+	if sec == nil {
+		return nil
+	}
+	cl, _ := sec.Data.(*dptxt.Checklist)
+	return cl
+}
+
+func (checklistType) Compare(a, b *dptxt.Section) int {
+	// 進捗の百分率で比較する。対応するセクションがなければ0%として扱う。
+	av := 0
+	bv := 0
+	if cl := sectionChecklist(a); cl != nil {
+		av = cl.Percent()
+	}
+	if cl := sectionChecklist(b); cl != nil {
+		bv = cl.Percent()
+	}
+	return av - bv
+}
+
+func (ctype checklistType) WriteHTML(config *DustpanConfig, sec *dptxt.Section, w *bufio.Writer) error {
+	if cl := sectionChecklist(sec); cl != nil {
+		_, err := w.WriteString(fmt.Sprintf(divProgressFmt, cl.Done, cl.Total, cl.Percent(), cl.Total, cl.Done, cl.String(), cl.Percent()))
+		if err != nil {
+			return err
+		}
+	}
+	return ctype.TextType.WriteHTML(config, sec, w)
+}
+
+func (ctype checklistType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	if cl := sectionChecklist(sec); cl != nil {
+		_, err := w.WriteString(cl.String())
+		return err
+	}
+	return ctype.TextType.WriteCsv(sec, w)
+}
+
+func (checklistType) WriteJSON(sec *dptxt.Section, w *bufio.Writer) error {
+	return jsonWriteObject(sec, w, func(sec *dptxt.Section, w *bufio.Writer) error {
+		if cl := sectionChecklist(sec); cl != nil {
+			_, err := w.WriteString(fmt.Sprintf(jsonChecklistFmt, cl.String(), cl.Done, cl.Total, cl.Percent()))
+			if err != nil {
+				return err
+			}
+		}
+		return jsonWriteValues(sec, w)
+	})
+}
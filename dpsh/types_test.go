@@ -0,0 +1,361 @@
+package dpsh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/healthy-tiger/dustpan/dptxt"
+)
+
+// testNow テストで前処理を始めた日時とする日時
+var testNow = time.Date(2020, 1, 10, 9, 0, 0, 0, time.Local)
+
+// loadTestConfig JSONで書いた設定を、設定ファイルと同じように読み込む。
+func loadTestConfig(t *testing.T, src string) *DustpanConfig {
+	t.Helper()
+	f, err := ioutil.TempFile("", "dpsh_test_*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(src)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := new(DustpanConfig)
+	if err = LoadConfig(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// loadTestDocs 文書を解析してtestNowに前処理する。引数はファイル名と文書の内容を交互に並べる。
+func loadTestDocs(t *testing.T, config *DustpanConfig, srcs ...string) []*dptxt.Document {
+	t.Helper()
+	docs := make([]*dptxt.Document, 0, len(srcs)/2)
+	for i := 0; i+1 < len(srcs); i += 2 {
+		doc := new(dptxt.Document)
+		err := dptxt.ParseDocumentWithOptions(srcs[i], strings.NewReader(srcs[i+1]), doc, config.ParseOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	preprocessAllDocsAt(config, docs, testNow)
+	return docs
+}
+
+// writeString fがwに書き出した文字列を返す。
+func writeString(t *testing.T, f func(w *bufio.Writer) error) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := f(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// csvRow 文書をCSVの一行として書き出した文字列を返す。
+func csvRow(t *testing.T, config *DustpanConfig, doc *dptxt.Document) string {
+	t.Helper()
+	return writeString(t, func(w *bufio.Writer) error {
+		return csvWriteDocument(config, doc, w)
+	})
+}
+
+func TestLookupType(t *testing.T) {
+	tests := []struct {
+		name string
+		vt   ValueType
+		ok   bool
+	}{
+		{"text", TextType{}, true},
+		{"Number", numberType{}, true},
+		{"DATE", dateType{}, true},
+		{"datetime", dateType{clock: true}, true},
+		{"Deadline", dateType{clock: true, deadline: true}, true},
+		{"log", logType{}, true},
+		{"filename", filenameType{}, true},
+		{"checklist", checklistType{}, true},
+		{"unknown", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		vt, ok := LookupType(tt.name)
+		if ok != tt.ok || vt != tt.vt {
+			t.Errorf("%q: %#v, %v", tt.name, vt, ok)
+		}
+	}
+
+	// 未知の型とカラムの定義のないカラムはtext型として扱う。
+	config := &DustpanConfig{ColumnDefs: []ColumnConfig{{Name: "v", Type: "unknown"}}}
+	if vt := config.ColumnDefs[0].ValueType(); vt != (TextType{}) {
+		t.Errorf("unknown: %#v", vt)
+	}
+	if vt := config.columnType("none"); vt != (TextType{}) {
+		t.Errorf("none: %#v", vt)
+	}
+}
+
+// upperType 大文字にしてCSVに書き出すテスト用の型
+type upperType struct {
+	TextType
+}
+
+func (upperType) WriteCsv(sec *dptxt.Section, w *bufio.Writer) error {
+	_, err := w.WriteString(strings.ToUpper(sec.PeekString()))
+	return err
+}
+
+func TestRegisterType(t *testing.T) {
+	RegisterType("Upper", upperType{})
+	defer delete(valueTypes, "upper")
+
+	if vt, ok := LookupType("UPPER"); !ok || vt != (upperType{}) {
+		t.Fatalf("%#v, %v", vt, ok)
+	}
+	config := loadTestConfig(t, `{ "columns": [{ "name": "title", "type": "upper" }] }`)
+	doc := loadTestDocs(t, config, "a.txt", "@title: hello\n")[0]
+	if actual := csvRow(t, config, doc); actual != `"HELLO"` {
+		t.Error(actual)
+	}
+
+	tests := []struct {
+		cc  ColumnConfig
+		err error
+	}{
+		{ColumnConfig{Name: "v", Type: "UPPER"}, nil},
+		{ColumnConfig{Type: "text"}, ErrorNoColumnName},
+		{ColumnConfig{Name: "v"}, ErrorNoColumnType},
+		{ColumnConfig{Name: "v", Type: "lower"}, ErrorUnknownColumnType},
+	}
+	for _, tt := range tests {
+		if err := validateColumnConfig(&tt.cc); err != tt.err {
+			t.Errorf("%+v: %v", tt.cc, err)
+		}
+	}
+}
+
+func TestPreprocessColumns(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "title", "type": "text" },
+			{ "name": "count", "type": "number" },
+			{ "name": "date", "type": "date" },
+			{ "name": "at", "type": "datetime" },
+			{ "name": "due", "type": "deadline" },
+			{ "name": "todo", "type": "checklist" },
+			{ "name": "file", "type": "filename" }
+		],
+		"html": { "display": ["date", "at", "due", "todo", "file"] }
+	}`)
+	src := `@title: hello *world*
+@count: -42
+@date: 2020/1/2
+@at: 2020/1/2 10:30
+@due: 2020/1/9
+@todo:
+- [x] a
+- [ ] b
+`
+	doc := loadTestDocs(t, config, "dir/a.txt", src)[0]
+	for name, sec := range doc.Sections {
+		if sec.Error != nil {
+			t.Error(name, sec.Error)
+		}
+	}
+	if actual := csvRow(t, config, doc); actual != `"hello world","-42","2020/1/2","2020/1/2 10:30","2020/1/9","1/2","a"` {
+		t.Error(actual)
+	}
+
+	// 解析した値はsec.Dataに格納する。
+	if n := sectionNumber(doc.Lookup("count")); n != -42 {
+		t.Error("count", n)
+	}
+	if dv := SectionDate(doc.Lookup("at")); dv == nil || !dv.HasClock || dv.Time.Hour() != 10 || dv.Expired {
+		t.Error("at", dv)
+	}
+	if dv := SectionDate(doc.Lookup("due")); dv == nil || dv.HasClock || !dv.Expired {
+		t.Error("due", dv)
+	}
+	if cl := sectionChecklist(doc.Lookup("todo")); cl == nil || cl.Done != 1 || cl.Total != 2 {
+		t.Error("todo", cl)
+	}
+
+	json := writeString(t, func(w *bufio.Writer) error {
+		return jsonWriteDocument(config, doc, w)
+	})
+	expected := `{"filename":"dir/a.txt","sections":{` +
+		`"date":{"date":{ "year":2020, "month":1, "day":2 }},` +
+		`"at":{"date":{ "year":2020, "month":1, "day":2, "hour":10, "min":30, "sec":0 }},` +
+		`"due":{"date":{ "year":2020, "month":1, "day":9, "expired":true }},` +
+		`"todo":{"progress":"1/2", "done":1, "total":2, "percent":50, "value":[{ "value":["- [x] a","- [ ] b"]}]},` +
+		`"file":"a"} }`
+	if json != expected {
+		t.Error(json)
+	}
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		err   error
+		line  int
+	}{
+		{"number", "4x", strconv.ErrSyntax, 2},
+		{"number", "1\n  2", ErrorMultipleValue, 2},
+		{"date", "2020/2/30", ErrorInvalidDate, 2},
+		{"date", "2020年13月1日", ErrorInvalidDate, 2},
+		{"date", "2020/1/2 10:00", ErrorMultipleValue, 2},
+		{"deadline", "+3d", ErrorNoBaseDate, 2},
+	}
+	for _, tt := range tests {
+		config := loadTestConfig(t, `{ "columns": [{ "name": "v", "type": "`+tt.typ+`" }] }`)
+		doc := loadTestDocs(t, config, "a.txt", "@title: t\n@v: "+tt.value+"\n")[0]
+		sec := doc.Lookup("v")
+		var ve *ValueError
+		if !errors.As(sec.Error, &ve) || !errors.Is(ve, tt.err) || ve.Linenum != tt.line {
+			t.Errorf("%s %q: %v", tt.typ, tt.value, sec.Error)
+			continue
+		}
+		// 解析できない値はそのまま出力する。
+		if actual := csvRow(t, config, doc); actual != `"`+strings.Replace(tt.value, "\n  ", "\n", 1)+`"` {
+			t.Errorf("%s %q: %s", tt.typ, tt.value, actual)
+		}
+	}
+}
+
+func TestValueError(t *testing.T) {
+	ve := NewValueError("a.txt", 3, ErrorMultipleValue)
+	if ve.Error() != "a.txt:3 複数の値" || !errors.Is(ve, ErrorMultipleValue) {
+		t.Error(ve)
+	}
+
+	// 値の誤りの範囲は日付の部分だけにする。
+	config := loadTestConfig(t, `{ "columns": [{ "name": "v", "type": "date" }] }`)
+	doc := loadTestDocs(t, config, "a.txt", "@title: t\n@v: 2020年13月1日\n")[0]
+	if !errors.As(doc.Lookup("v").Error, &ve) {
+		t.Fatal(doc.Lookup("v").Error)
+	}
+	if ve.Span.Start.Line != 2 || ve.Span.Start.Column != 5 || ve.Span.End.Column != 15 {
+		t.Error(ve.Span)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		typ  string
+		a, b string // 空ならセクションがない
+		sign int
+	}{
+		{"text", "a", "b", -1},
+		{"text", "", "a", -1},
+		{"text", "a", "a", 0},
+		{"number", "10", "9", 1},
+		{"number", "", "-1", 1},
+		{"date", "2020/1/2", "2020/1/1", 1},
+		{"date", "", "1999/1/1", -1},
+		{"datetime", "2020/1/2 9:00", "2020/1/2 10:00", -1},
+		{"deadline", "2020/1/2", "2020/1/2 0:00", 0},
+		{"checklist", "- [x] a", "- [ ] a", 1},
+		{"checklist", "", "- [ ] a", 0},
+		// ログは日付を取り除いた値で比べる。
+		{"log", "b(2020/1/1)", "b 2(2019/1/1)", -1},
+		{"log", "a(2020/1/2)\n\nc(2020/1/3)", "a(2020/1/1)", 0},
+	}
+	for _, tt := range tests {
+		config := loadTestConfig(t, `{ "columns": [{ "name": "v", "type": "`+tt.typ+`" }] }`)
+		srcs := make([]string, 0, 4)
+		for i, v := range []string{tt.a, tt.b} {
+			src := "@title: t\n"
+			if len(v) > 0 {
+				src += "@v: " + v + "\n"
+			}
+			srcs = append(srcs, strconv.Itoa(i)+".txt", src)
+		}
+		docs := loadTestDocs(t, config, srcs...)
+		r := config.ColumnDefs[0].ValueType().Compare(docs[0].Lookup("v"), docs[1].Lookup("v"))
+		if (r < 0 && tt.sign >= 0) || (r > 0 && tt.sign <= 0) || (r == 0 && tt.sign != 0) {
+			t.Errorf("%v %q %q: %d", tt.typ, tt.a, tt.b, r)
+		}
+	}
+}
+
+func TestLogType(t *testing.T) {
+	config := loadTestConfig(t, `{ "columns": [{ "name": "log", "type": "log" }] }`)
+	src := "@log: a(2020/1/2)\n\nb\nc(2020/1/3 10:00 済)\n\n```\nx\n```\n\nd(2020/13/1)\n"
+	doc := loadTestDocs(t, config, "a.txt", src)[0]
+	// 文書のパラグラフは変更しないので、前処理した文書も元のとおりに書き戻せる。
+	var buf bytes.Buffer
+	if err := dptxt.WriteDocument(&buf, doc); err != nil || buf.String() != src {
+		t.Errorf("%q, %v", buf.String(), err)
+	}
+
+	paras := logParagraphs(doc.Lookup("log"))
+	tests := []struct {
+		value  string
+		time   string
+		suffix string
+		err    error
+	}{
+		{"a", "2020-01-02 00:00", "", nil},
+		{"b\nc", "2020-01-03 10:00", "済", nil},
+		// コードブロックには日付を要求しない。
+		{"x", "", "", nil},
+		{"d(2020/13/1)", "", "", ErrorInvalidDate},
+	}
+	if len(paras) != len(tests) {
+		t.Fatal(paras)
+	}
+	for i, tt := range tests {
+		p := paras[i]
+		tm := ""
+		if p.Time != nil {
+			tm = p.Time.Format("2006-01-02 15:04")
+		}
+		if strings.Join(p.Value, "\n") != tt.value || tm != tt.time || p.TimeSuffix != tt.suffix || !errors.Is(p.Error, tt.err) {
+			t.Errorf("%d: %q %q %q %v", i, p.Value, tm, p.TimeSuffix, p.Error)
+		}
+	}
+	var ve *ValueError
+	if !errors.As(paras[3].Error, &ve) || ve.Linenum != 10 {
+		t.Error(paras[3].Error)
+	}
+	if !paras[1].HasClock || paras[0].HasClock {
+		t.Error("HasClock")
+	}
+	// 出力には日付を取り除いたパラグラフを使う。
+	if actual := csvRow(t, config, doc); actual != "\"a\n\nb\nc\n\nx\n\nd(2020/13/1)\"" {
+		t.Error(actual)
+	}
+}
+
+func TestDeprecatedFields(t *testing.T) {
+	config := loadTestConfig(t, `{
+		"columns": [
+			{ "name": "n", "type": "number" },
+			{ "name": "d", "type": "deadline" }
+		]
+	}`)
+	src := `@n: 3
+@d: 2020/1/9 10:00
+`
+	doc := loadTestDocs(t, config, "a.txt", src)[0]
+	if n := doc.Lookup("n"); n.Number != 3 {
+		t.Error("Number", n.Number)
+	}
+	if d := doc.Lookup("d"); d.Time == nil || !d.Time.Equal(SectionDate(d).Time) || !d.Expired {
+		t.Error("Time", d.Time, d.Expired)
+	}
+}
@@ -0,0 +1,117 @@
+package dptxt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CheckItem チェックリストの項目
+type CheckItem struct {
+	Index int    // Valueの中の行の番号
+	Done  bool   // チェックされているか
+	Text  string // チェックボックスより後ろの文字列
+}
+
+// Checklist チェックリストの進捗
+type Checklist struct {
+	Done  int // チェックされた項目の数
+	Total int // 項目の数
+}
+
+// 箇条書きの記号
+var bulletPrefixes = []string{"- ", "* ", "+ ", "・"}
+
+func isOpenBracket(r rune) bool {
+	return r == '[' || r == '［'
+}
+
+func isCloseBracket(r rune) bool {
+	return r == ']' || r == '］'
+}
+
+// isCheckMark チェックボックスの中がチェックされた印かどうか
+func isCheckMark(r rune) bool {
+	switch r {
+	case 'x', 'X', 'ｘ', 'Ｘ', '×', '✓', '✔', '✗', '☑':
+		return true
+	}
+	return false
+}
+
+// ParseCheckItem 行が「[ ]」「[x]」「［×］」のようなチェックボックスで始まっていれば、
+// チェックされているかとチェックボックスより後ろの文字列を返す。行頭の「- 」「・」のような箇条書きの記号は読み飛ばす。
+func ParseCheckItem(line string) (bool, string, bool) {
+	b := strings.TrimLeftFunc(line, isSp)
+	for _, bullet := range bulletPrefixes {
+		if strings.HasPrefix(b, bullet) {
+			b = strings.TrimLeftFunc(b[len(bullet):], isSp)
+			break
+		}
+	}
+
+	r, s := utf8.DecodeRuneInString(b)
+	if !isOpenBracket(r) {
+		return false, empty, false
+	}
+	b = b[s:]
+	done := false
+	r, s = utf8.DecodeRuneInString(b)
+	if isCheckMark(r) {
+		done = true
+		b = b[s:]
+	} else if isSp(r) {
+		b = b[s:]
+	}
+	r, s = utf8.DecodeRuneInString(b)
+	if !isCloseBracket(r) {
+		return false, empty, false
+	}
+	b = b[s:]
+	// 「[x]abc」のように続けて書いた場合は項目とみなさない。
+	if r, _ = utf8.DecodeRuneInString(b); len(b) > 0 && !isSp(r) {
+		return false, empty, false
+	}
+	return done, strings.TrimFunc(b, isSp), true
+}
+
+// CheckItems パラグラフの中のチェックリストの項目を返す。コードブロックの中は対象外。
+func (p *Paragraph) CheckItems() []CheckItem {
+	items := make([]CheckItem, 0)
+	if p.Kind == VerbatimParagraph {
+		return items
+	}
+	for i, v := range p.Value {
+		if done, text, ok := ParseCheckItem(v); ok {
+			items = append(items, CheckItem{i, done, text})
+		}
+	}
+	return items
+}
+
+// CountChecklist セクションのすべてのパラグラフのチェックリストの進捗を数える。
+func (s *Section) CountChecklist() Checklist {
+	var c Checklist
+	for _, p := range s.Value {
+		for _, item := range p.CheckItems() {
+			c.Total++
+			if item.Done {
+				c.Done++
+			}
+		}
+	}
+	return c
+}
+
+// Percent 進捗の百分率を返す。項目がなければ0を返す。
+func (c *Checklist) Percent() int {
+	if c.Total == 0 {
+		return 0
+	}
+	return c.Done * 100 / c.Total
+}
+
+// String 「3/5」のような進捗の表記を返す。
+func (c *Checklist) String() string {
+	return strconv.Itoa(c.Done) + "/" + strconv.Itoa(c.Total)
+}
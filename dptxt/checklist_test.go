@@ -0,0 +1,52 @@
+package dptxt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseCheckItem(t *testing.T) {
+	tests := []struct {
+		src  string
+		done bool
+		text string
+		ok   bool
+	}{
+		{"[ ] 設計", false, "設計", true},
+		{"[] 設計", false, "設計", true},
+		{"[x] 実装", true, "実装", true},
+		{"[X]", true, "", true},
+		{"［×］　テスト", true, "テスト", true},
+		{"［　］ レビュー", false, "レビュー", true},
+		{"- [x] リリース", true, "リリース", true},
+		{"・[ ] 告知", false, "告知", true},
+		{"[x]abc", false, "", false},
+		{"[a] abc", false, "", false},
+		{"[x", false, "", false},
+		{"abc [x]", false, "", false},
+	}
+	for _, tt := range tests {
+		done, text, ok := ParseCheckItem(tt.src)
+		if done != tt.done || text != tt.text || ok != tt.ok {
+			t.Errorf("%q: %v, %q, %v", tt.src, done, text, ok)
+		}
+	}
+}
+
+func TestCountChecklist(t *testing.T) {
+	src := "@todo: 準備\n[x] 設計\n[ ] 実装\n\n```\n[x] コードブロックの中\n```\n\n- [x] テスト\n"
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := doc.Sections["todo"]
+	items := sec.Value[0].CheckItems()
+	if len(items) != 2 || items[0] != (CheckItem{1, true, "設計"}) || items[1] != (CheckItem{2, false, "実装"}) {
+		t.Error(items)
+	}
+	c := sec.CountChecklist()
+	if c != (Checklist{2, 3}) || c.Percent() != 66 || c.String() != "2/3" {
+		t.Error(c)
+	}
+}
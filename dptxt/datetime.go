@@ -0,0 +1,194 @@
+package dptxt
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DateTime 時刻を含むかもしれない日付
+type DateTime struct {
+	Year, Month, Day     int
+	Hour, Minute, Second int
+	HasClock             bool // 時刻が指定されているか
+	HasZone              bool // 時差が指定されているか
+	Offset               int  // UTCからの時差(秒)
+}
+
+// Time time.Timeにする。時差が指定されていない場合はlocの時刻とする。
+// 存在しない日付や時刻の場合はfalseを返す。
+func (dt *DateTime) Time(loc *time.Location) (time.Time, bool) {
+	if dt.HasZone {
+		loc = time.FixedZone("", dt.Offset)
+	}
+	t := time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, 0, loc)
+	// time.Date()の結果が正規化されていないことを確認する。
+	ok := t.Year() == dt.Year && t.Month() == time.Month(dt.Month) && t.Day() == dt.Day &&
+		t.Hour() == dt.Hour && t.Minute() == dt.Minute && t.Second() == dt.Second
+	return t, ok
+}
+
+// ParseDateTime 文字列の先頭の日付と、続く時刻を解析する。時刻は省略できる。日付と時刻より後ろの部分も返す。
+// 時刻は「17:00」「17:00:30」「17時」「17時30分」のように書き、日付とは空白かTで区切る。
+// 時刻の直後には「Z」「+09:00」「+0900」のように時差を書ける。
+func ParseDateTime(b string) (DateTime, string, error) {
+	dt, post, _, _, err := ParseDateTimeRange(b)
+	return dt, post, err
+}
+
+// ParseDateTimeRange ParseDateTimeと同じように日付と時刻を解析し、bの中の日付と時刻の範囲をバイト数で返す。
+func ParseDateTimeRange(b string) (DateTime, string, int, int, error) {
+	var dt DateTime
+	year, month, day, rest, begin, end, err := parseDate(b)
+	dt.Year, dt.Month, dt.Day = year, month, day
+	if err != nil {
+		return dt, "", begin, end, err
+	}
+	fail := func(rest string, err error) (DateTime, string, int, int, error) {
+		_, s := utf8.DecodeRuneInString(rest)
+		return dt, "", begin, len(b) - len(rest) + s, err
+	}
+
+	r, s := utf8.DecodeRuneInString(rest)
+	switch {
+	case len(rest) == 0:
+		return dt, "", begin, end, nil
+	case r == 'T':
+		// ISO 8601の形式では時刻を省略できない。
+		c, ok, err := parseClock(rest[s:], &dt)
+		if err != nil {
+			return fail(c, err)
+		} else if !ok {
+			return fail(rest[s:], ErrorInvalidTimeFormat)
+		}
+		rest = c
+	case isSp(r):
+		c, ok, err := parseClock(strings.TrimLeftFunc(rest, isSp), &dt)
+		if err != nil {
+			return fail(c, err)
+		} else if !ok {
+			// 時刻でなければ日付より後ろの部分とする。
+			return dt, strings.TrimFunc(rest, isSp), begin, end, nil
+		}
+		rest = c
+	default:
+		return fail(rest, ErrorUnknownDateSuffix)
+	}
+
+	// 時刻の直後の文字がないか、空白でなければエラー
+	r, _ = utf8.DecodeRuneInString(rest)
+	if len(rest) > 0 && !isSp(r) {
+		return fail(rest, ErrorInvalidTimeFormat)
+	}
+	return dt, strings.TrimFunc(rest, isSp), begin, len(b) - len(rest), nil
+}
+
+// ParseLogDateTime ParseLogDateと同じようにカッコの中の日付を解析する。日付の後ろには時刻を書ける。
+func ParseLogDateTime(b string) (DateTime, string, string, error) {
+	dt, pre, post, _, _, err := ParseLogDateTimeRange(b)
+	return dt, pre, post, err
+}
+
+// ParseLogDateTimeRange ParseLogDateTimeと同じように日付と時刻を解析し、bの中の日付と時刻の範囲をバイト数で返す。
+func ParseLogDateTimeRange(b string) (DateTime, string, string, int, int, error) {
+	pre, inner, base, begin, end, err := splitLogDate(b)
+	if err != nil {
+		return DateTime{}, pre, "", begin, end, err
+	}
+	dt, post, begin, end, err := ParseDateTimeRange(inner)
+	return dt, pre, post, base + begin, base + end, err
+}
+
+// parseClock bの先頭の時刻と時差を解析してdtに格納する。時刻でなければfalseを返す。
+// エラーの場合は誤りが見つかった位置からの文字列を返す。
+func parseClock(b string, dt *DateTime) (string, bool, error) {
+	rest, hour, r, n := DecodeDigit(b)
+	if n == 0 || !(isColon(r) || r == '時') {
+		return b, false, nil
+	}
+	if n > 2 {
+		return b, true, ErrorInvalidTimeFormat
+	}
+	_, s := utf8.DecodeRuneInString(rest)
+	rest = rest[s:]
+	dt.Hour, dt.Minute, dt.Second = hour, 0, 0
+
+	if isColon(r) {
+		// 17:30、17:30:15
+		var c string
+		c, dt.Minute, r, n = DecodeDigit(rest)
+		if n != 2 {
+			return rest, true, ErrorInvalidTimeFormat
+		}
+		rest = c
+		if isColon(r) {
+			_, s = utf8.DecodeRuneInString(rest)
+			c, dt.Second, _, n = DecodeDigit(rest[s:])
+			if n != 2 {
+				return rest, true, ErrorInvalidTimeFormat
+			}
+			rest = c
+		}
+	} else {
+		// 17時、17時30分、17時30分15秒
+		for _, suffix := range []rune{'分', '秒'} {
+			c, v, r, n := DecodeDigit(rest)
+			if n == 0 {
+				break
+			}
+			if n > 2 || r != suffix {
+				return rest, true, ErrorInvalidTimeFormat
+			}
+			if suffix == '分' {
+				dt.Minute = v
+			} else {
+				dt.Second = v
+			}
+			_, s = utf8.DecodeRuneInString(c)
+			rest = c[s:]
+		}
+	}
+	dt.HasClock = true
+
+	rest, err := parseZone(rest, dt)
+	return rest, true, err
+}
+
+// parseZone bの先頭にZ、+09:00、+0900、+09のような時差があればdtに格納する。
+func parseZone(b string, dt *DateTime) (string, error) {
+	r, s := utf8.DecodeRuneInString(b)
+	if r == 'Z' {
+		dt.HasZone = true
+		dt.Offset = 0
+		return b[s:], nil
+	}
+	if r != '+' && r != '-' {
+		return b, nil
+	}
+	rest, v, r, n := DecodeDigit(b[s:])
+	var hour, minute int
+	switch {
+	case n == 4:
+		hour, minute = v/100, v%100
+	case n == 2 && isColon(r):
+		hour = v
+		_, s2 := utf8.DecodeRuneInString(rest)
+		rest, minute, _, n = DecodeDigit(rest[s2:])
+		if n != 2 {
+			return b, ErrorInvalidTimeFormat
+		}
+	case n == 2:
+		hour = v
+	default:
+		return b, ErrorInvalidTimeFormat
+	}
+	if hour > 23 || minute > 59 {
+		return b, ErrorInvalidTimeFormat
+	}
+	dt.HasZone = true
+	dt.Offset = hour*3600 + minute*60
+	if b[0] == '-' {
+		dt.Offset = -dt.Offset
+	}
+	return rest, nil
+}
@@ -0,0 +1,78 @@
+package dptxt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected DateTime
+		post     string
+	}{
+		{"2024-03-01", DateTime{Year: 2024, Month: 3, Day: 1}, ""},
+		{"2024-03-01 完了", DateTime{Year: 2024, Month: 3, Day: 1}, "完了"},
+		{"2024-03-01 17:00", DateTime{2024, 3, 1, 17, 0, 0, true, false, 0}, ""},
+		{"2024/3/1　１７：３０：１５ 完了", DateTime{2024, 3, 1, 17, 30, 15, true, false, 0}, "完了"},
+		{"2024年3月1日 17時", DateTime{2024, 3, 1, 17, 0, 0, true, false, 0}, ""},
+		{"2024年3月1日 9時5分", DateTime{2024, 3, 1, 9, 5, 0, true, false, 0}, ""},
+		{"2024-03-01T17:30:00Z", DateTime{2024, 3, 1, 17, 30, 0, true, true, 0}, ""},
+		{"2024-03-01T17:30+09:00", DateTime{2024, 3, 1, 17, 30, 0, true, true, 9 * 3600}, ""},
+		{"2024-03-01T17:30-0530 x", DateTime{2024, 3, 1, 17, 30, 0, true, true, -(5*3600 + 30*60)}, "x"},
+	}
+	for _, tt := range tests {
+		dt, post, err := ParseDateTime(tt.src)
+		if err != nil || dt != tt.expected || post != tt.post {
+			t.Errorf("%q: %+v, %q, %v", tt.src, dt, post, err)
+		}
+	}
+}
+
+func TestParseDateTimeErr(t *testing.T) {
+	tests := []struct {
+		src        string
+		begin, end int
+		err        error
+	}{
+		{"2024-03-01T", 0, 11, ErrorInvalidTimeFormat},
+		{"2024-03-01T1700", 0, 12, ErrorInvalidTimeFormat},
+		{"2024-03-01 17:0", 0, 15, ErrorInvalidTimeFormat},
+		{"2024-03-01 17:00x", 0, 17, ErrorInvalidTimeFormat},
+		{"2024-03-01 17時3", 0, 17, ErrorInvalidTimeFormat},
+		{"2024-03-01 17:00+9", 0, 17, ErrorInvalidTimeFormat},
+		{"2024-03-01x", 0, 11, ErrorUnknownDateSuffix},
+	}
+	for _, tt := range tests {
+		_, _, begin, end, err := ParseDateTimeRange(tt.src)
+		if begin != tt.begin || end != tt.end || err != tt.err {
+			t.Errorf("%q: %d, %d, %v", tt.src, begin, end, err)
+		}
+	}
+}
+
+func TestParseLogDateTime(t *testing.T) {
+	dt, pre, post, begin, end, err := ParseLogDateTimeRange("打合せ(2024-03-01 17:00 済)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dt != (DateTime{2024, 3, 1, 17, 0, 0, true, false, 0}) || pre != "打合せ" || post != "済" || begin != 10 || end != 26 {
+		t.Error(dt, pre, post, begin, end)
+	}
+}
+
+func TestDateTimeTime(t *testing.T) {
+	dt := DateTime{2024, 3, 1, 17, 30, 0, true, true, 9 * 3600}
+	tm, ok := dt.Time(time.UTC)
+	if !ok || !tm.Equal(time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)) {
+		t.Error(tm, ok)
+	}
+	dt = DateTime{2024, 3, 1, 24, 0, 0, true, false, 0}
+	if _, ok = dt.Time(time.UTC); ok {
+		t.Error("24:00")
+	}
+	dt = DateTime{Year: 2023, Month: 2, Day: 29}
+	if _, ok = dt.Time(time.UTC); ok {
+		t.Error("2023-02-29")
+	}
+}
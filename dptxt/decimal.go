@@ -0,0 +1,233 @@
+package dptxt
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decimal 10進数の小数。Value×10^-Scaleを表わす。
+type Decimal struct {
+	Value int64
+	Scale int // 小数点以下の桁数
+}
+
+// 有効桁数の上限
+const maxDecimalDigits = 18
+
+// 数値の前に書ける通貨記号
+var currencyPrefixes = []string{"¥", "￥", "$", "＄", "€", "£"}
+
+// 数値の後ろに書ける通貨の単位
+var currencySuffixes = []string{"円", "ドル", "ユーロ"}
+
+func isThousandsSeparator(r rune) bool {
+	return r == ',' || r == '，'
+}
+
+func isDecimalPoint(r rune) bool {
+	return r == '.' || r == '．'
+}
+
+// decodeDecimal bの先頭の「1,234.5」のような数値を解析し、残りと数字の数を返す。
+// 桁区切りは3桁ごとの位置にだけ書ける。全角の数字も使える。
+func decodeDecimal(b string) (Decimal, string, int, error) {
+	var d Decimal
+	digits := 0
+	// 桁区切りの後ろの数字の数。桁区切りがなければ-1。
+	group := -1
+	fraction := false
+	for len(b) > 0 {
+		_, n, s := DecodeSingleDigit(b)
+		if n >= 0 {
+			if digits >= maxDecimalDigits {
+				return d, b, digits, ErrorInvalidNumber
+			}
+			d.Value = d.Value*10 + int64(n)
+			digits++
+			if fraction {
+				d.Scale++
+			} else if group >= 0 {
+				group++
+			}
+			b = b[s:]
+			continue
+		}
+		r, s := utf8.DecodeRuneInString(b)
+		if isThousandsSeparator(r) && !fraction && digits > 0 && ((group < 0 && digits <= 3) || group == 3) {
+			// 桁区切りの後ろには数字が必要。
+			if _, n, _ := DecodeSingleDigit(b[s:]); n < 0 {
+				break
+			}
+			group = 0
+			b = b[s:]
+			continue
+		}
+		if isDecimalPoint(r) && !fraction && digits > 0 {
+			if _, n, _ := DecodeSingleDigit(b[s:]); n < 0 {
+				return d, b, digits, ErrorInvalidNumber
+			}
+			fraction = true
+			b = b[s:]
+			continue
+		}
+		break
+	}
+	if group >= 0 && group != 3 {
+		return d, b, digits, ErrorInvalidNumber
+	}
+	return d, b, digits, nil
+}
+
+// ParseDecimal 「-1,234.5」「１２．５」のような数値を解析する。
+// 符号(全角も可)、全角の数字、3桁ごとの桁区切り(カンマまたは全角のカンマ)、小数点(ピリオドまたは全角のピリオド)を使える。
+func ParseDecimal(b string) (Decimal, error) {
+	d, unit, err := ParseAmount(b)
+	if err == nil && len(unit) > 0 {
+		err = ErrorInvalidNumber
+	}
+	return d, err
+}
+
+// ParseAmount 「¥1,200」「1,200円」「$3.50」のような通貨記号の付いた数値を解析し、通貨記号と一緒に返す。
+// 通貨記号は省略できる。
+func ParseAmount(b string) (Decimal, string, error) {
+	b = strings.TrimFunc(b, isSp)
+	unit := empty
+	for _, p := range currencyPrefixes {
+		if strings.HasPrefix(b, p) {
+			unit = p
+			b = strings.TrimLeftFunc(b[len(p):], isSp)
+			break
+		}
+	}
+	r, s := utf8.DecodeRuneInString(b)
+	negative := false
+	switch r {
+	case '+', '＋':
+		b = b[s:]
+	case '-', '－', '−':
+		negative = true
+		b = b[s:]
+	}
+	d, rest, digits, err := decodeDecimal(b)
+	if err != nil {
+		return d, unit, err
+	}
+	if digits == 0 {
+		return d, unit, ErrorInvalidNumber
+	}
+	rest = strings.TrimLeftFunc(rest, isSp)
+	if len(unit) == 0 {
+		for _, x := range currencySuffixes {
+			if rest == x {
+				unit = x
+				rest = empty
+				break
+			}
+		}
+	}
+	if len(rest) > 0 {
+		return d, unit, ErrorInvalidNumber
+	}
+	if negative {
+		d.Value = -d.Value
+	}
+	return d, unit, nil
+}
+
+// pow10 10のn乗
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// rescale 小数点以下の桁数をscaleにした値を返す。scaleはd.Scale以上でなければならない。
+// int64の範囲を超える場合はfalseを返す。
+func (d Decimal) rescale(scale int) (int64, bool) {
+	v := d.Value
+	for i := d.Scale; i < scale; i++ {
+		if v > math.MaxInt64/10 || v < math.MinInt64/10 {
+			return 0, false
+		}
+		v *= 10
+	}
+	return v, true
+}
+
+// Add dとeの和を返す。和がint64の範囲で表わせなければErrorNumberOutOfRangeを返す。
+func (d Decimal) Add(e Decimal) (Decimal, error) {
+	scale := d.Scale
+	if e.Scale > scale {
+		scale = e.Scale
+	}
+	a, aok := d.rescale(scale)
+	b, bok := e.rescale(scale)
+	if !aok || !bok || (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return Decimal{}, ErrorNumberOutOfRange
+	}
+	return Decimal{a + b, scale}, nil
+}
+
+// sign 値の符号を-1、0、1で返す。
+func (d Decimal) sign() int {
+	switch {
+	case d.Value < 0:
+		return -1
+	case d.Value > 0:
+		return 1
+	}
+	return 0
+}
+
+// Cmp dがeより小さければ負、大きければ正、等しければ0を返す。
+func (d Decimal) Cmp(e Decimal) int {
+	scale := d.Scale
+	if e.Scale > scale {
+		scale = e.Scale
+	}
+	// 桁数を揃えてint64の範囲を超えるなら、そちらの方が絶対値が大きい。
+	a, aok := d.rescale(scale)
+	if !aok {
+		return d.sign()
+	}
+	b, bok := e.rescale(scale)
+	if !bok {
+		return -e.sign()
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Float64 浮動小数点数にする。
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String 「-1234.50」のように桁区切りを付けずに、小数点以下の桁数を保って文字列にする。
+func (d Decimal) String() string {
+	v := d.Value
+	sign := empty
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	s := strconv.FormatInt(v, 10)
+	if d.Scale == 0 {
+		return sign + s
+	}
+	if len(s) <= d.Scale {
+		s = strings.Repeat("0", d.Scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d.Scale] + "." + s[len(s)-d.Scale:]
+}
@@ -0,0 +1,107 @@
+package dptxt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected Decimal
+		unit     string
+	}{
+		{"0", Decimal{0, 0}, ""},
+		{"-12", Decimal{-12, 0}, ""},
+		{"1,234,567", Decimal{1234567, 0}, ""},
+		{"１，２３４．５０", Decimal{123450, 2}, ""},
+		{"＋3.14", Decimal{314, 2}, ""},
+		{"¥1,200", Decimal{1200, 0}, "¥"},
+		{"$ 3.50", Decimal{350, 2}, "$"},
+		{"1,200 円", Decimal{1200, 0}, "円"},
+	}
+	for _, tt := range tests {
+		d, unit, err := ParseAmount(tt.src)
+		if err != nil || d != tt.expected || unit != tt.unit {
+			t.Errorf("%q: %+v, %q, %v", tt.src, d, unit, err)
+		}
+	}
+	for _, src := range []string{"", "-", "1.", ".5", "1,23", "1234,567", "12,3456", "1,2,3", "1.2.3", "1,000.5,0", "12x", "¥12円", "1234567890123456789"} {
+		if d, unit, err := ParseAmount(src); err != ErrorInvalidNumber {
+			t.Errorf("%q: %+v, %q, %v", src, d, unit, err)
+		}
+	}
+	if _, err := ParseDecimal("¥1"); err != ErrorInvalidNumber {
+		t.Error(err)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	a := Decimal{125, 2}
+	b := Decimal{-3, 0}
+	if s, err := a.Add(b); err != nil || s.String() != "-1.75" {
+		t.Error("Add", s, err)
+	}
+	if a.Cmp(b) <= 0 || b.Cmp(a) >= 0 || a.Cmp(Decimal{1250, 3}) != 0 {
+		t.Error("Cmp")
+	}
+
+	// 桁数の多い値を足すとint64の範囲を超える。
+	large := Decimal{999999999999999999, 0}
+	small := Decimal{1, 2}
+	if s, err := large.Add(small); err != ErrorNumberOutOfRange {
+		t.Error("Add", s, err)
+	}
+	if s, err := large.Add(Decimal{9000000000000000000, 0}); err != ErrorNumberOutOfRange {
+		t.Error("Add", s, err)
+	}
+	if s, err := (Decimal{-9000000000000000000, 0}).Add(Decimal{-large.Value, 0}); err != ErrorNumberOutOfRange {
+		t.Error("Add", s, err)
+	}
+	if s, err := (Decimal{1, 0}).Add(Decimal{-1, 17}); err != nil || s.String() != "0.99999999999999999" {
+		t.Error("Add", s, err)
+	}
+	// 桁数を揃えられなくても比較できる。
+	if large.Cmp(small) <= 0 || small.Cmp(large) >= 0 || (Decimal{-large.Value, 0}).Cmp(small) >= 0 || small.Cmp(Decimal{-large.Value, 0}) <= 0 {
+		t.Error("Cmp")
+	}
+	if s := (Decimal{5, 3}).String(); s != "0.005" {
+		t.Error("String", s)
+	}
+	if f := a.Float64(); f != 1.25 {
+		t.Error("Float64", f)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	day := 8 * time.Hour
+	tests := []struct {
+		src      string
+		expected time.Duration
+	}{
+		{"3h", 3 * time.Hour},
+		{"2.5d", 20 * time.Hour},
+		{"1週間", 40 * time.Hour},
+		{"1W", 40 * time.Hour},
+		{"1日 4時間", 12 * time.Hour},
+		{"1d4h30min", 12*time.Hour + 30*time.Minute},
+		{"９０分", 90 * time.Minute},
+		{"0.5h", 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.src, day)
+		if err != nil || d != tt.expected {
+			t.Errorf("%q: %v, %v", tt.src, d, err)
+		}
+	}
+	for _, src := range []string{"", "3", "h", "3m", "3x", "3h4", "1か月"} {
+		if d, err := ParseDuration(src, day); err != ErrorInvalidDuration {
+			t.Errorf("%q: %v, %v", src, d, err)
+		}
+	}
+	for _, src := range []string{"999999999999999999min", "200000w", "2000000h 2000000h"} {
+		if d, err := ParseDuration(src, day); err != ErrorNumberOutOfRange {
+			t.Errorf("%q: %v, %v", src, d, err)
+		}
+	}
+}
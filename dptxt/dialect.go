@@ -0,0 +1,125 @@
+package dptxt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Dialect セクション名の行の書き方を指定する構造体。ゼロ値は「@name:」の形式(DefaultDialect)になる。
+type Dialect struct {
+	// セクション名の前に置く文字列。続けて書いた数だけ深い子セクションになる。
+	// 空の場合は字下げせずにSuffixesを含む行をセクション名の行とする。この場合は子セクションを書けない。
+	Prefixes []string
+	// セクション名の後ろに置く文字列。セクション名の行で最初に現われたものをセクション名の終わりとする。
+	Suffixes []string
+}
+
+var (
+	// DefaultDialect 「@name: value」の形式
+	DefaultDialect = Dialect{Prefixes: []string{"@", "＠"}, Suffixes: []string{":", "："}}
+	// KeyValueDialect 「name = value」の形式
+	KeyValueDialect = Dialect{Suffixes: []string{"=", "＝"}}
+	// BracketDialect 「【name】value」の形式
+	BracketDialect = Dialect{Prefixes: []string{"【"}, Suffixes: []string{"】"}}
+)
+
+var dialects = map[string]*Dialect{
+	"default":  &DefaultDialect,
+	"keyvalue": &KeyValueDialect,
+	"bracket":  &BracketDialect,
+}
+
+// LookupDialect 名前から定義済みの書き方を返す。名前は「default」「keyvalue」「bracket」のいずれか。
+func LookupDialect(name string) (Dialect, bool) {
+	d, ok := dialects[strings.ToLower(name)]
+	if !ok {
+		return Dialect{}, false
+	}
+	return *d, true
+}
+
+// orDefault ゼロ値ならDefaultDialectを返す。
+func (d *Dialect) orDefault() *Dialect {
+	if len(d.Prefixes) == 0 && len(d.Suffixes) == 0 {
+		return &DefaultDialect
+	}
+	return d
+}
+
+// hasPrefix lineがプリフィックスで始まっていれば、そのバイト数を返す。
+func (d *Dialect) hasPrefix(line string) (int, bool) {
+	for _, p := range d.Prefixes {
+		if len(p) > 0 && strings.HasPrefix(line, p) {
+			return len(p), true
+		}
+	}
+	return 0, false
+}
+
+// indexSuffix line中で最初に現われるサフィックスの位置とバイト数を返す。見つからなければ-1を返す。
+func (d *Dialect) indexSuffix(line string) (int, int) {
+	i, s := -1, 0
+	for _, x := range d.Suffixes {
+		if len(x) == 0 {
+			continue
+		}
+		if j := strings.Index(line, x); j >= 0 && (i < 0 || j < i) {
+			i, s = j, len(x)
+		}
+	}
+	return i, s
+}
+
+// isHeader lineがセクション名の行として読まれるかどうかを返す。lineは行頭の空白を除く前の行。
+func (d *Dialect) isHeader(line string) bool {
+	if len(d.Prefixes) > 0 {
+		_, ok := d.hasPrefix(strings.TrimLeftFunc(line, isSp))
+		return ok
+	}
+	return d.startsHeader(line)
+}
+
+// startsHeader 値の行がそのままではセクション名の行として読まれてしまうかどうかを返す。
+func (d *Dialect) startsHeader(line string) bool {
+	if len(d.Prefixes) > 0 {
+		_, ok := d.hasPrefix(line)
+		return ok
+	}
+	// プリフィックスがない場合は、字下げやエスケープがされておらずセクション名が空でない行。
+	if r, _ := utf8.DecodeRuneInString(line); len(line) == 0 || isSp(r) || isBackslash(r) {
+		return false
+	}
+	i, _ := d.indexSuffix(line)
+	return i > 0 && strings.IndexFunc(line[:i], func(r rune) bool { return !isSp(r) }) >= 0
+}
+
+// unescape 行頭の「\@」を「@」にする。「\\@」は「\@」になる。
+// プリフィックスがない場合は、セクション名の行と間違えないように付けた行頭の「\」を外す。
+func (d *Dialect) unescape(line string) string {
+	rest := strings.TrimLeftFunc(line, isBackslash)
+	if len(rest) < len(line) && d.startsHeader(rest) {
+		return line[1:]
+	}
+	return line
+}
+
+// escape unescapeの逆の変換をする。
+func (d *Dialect) escape(line string) string {
+	rest := strings.TrimLeftFunc(line, isBackslash)
+	if d.startsHeader(rest) {
+		return "\\" + line
+	}
+	return line
+}
+
+// header 追加されたセクションのセクション名の行を返す。
+func (d *Dialect) header(name string, depth int) string {
+	suffix := empty
+	if len(d.Suffixes) > 0 {
+		suffix = d.Suffixes[0]
+	}
+	if len(d.Prefixes) == 0 {
+		return name + " " + suffix
+	}
+	return strings.Repeat(d.Prefixes[0], depth) + name + suffix
+}
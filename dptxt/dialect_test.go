@@ -0,0 +1,93 @@
+package dptxt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func parseDialect(t *testing.T, src string, d Dialect) *Document {
+	var doc Document
+	err := ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, &ParseOptions{Dialect: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := writeString(t, &doc); actual != src {
+		t.Errorf("round trip\nexpected: %q\nactual:   %q", src, actual)
+	}
+	return &doc
+}
+
+func TestKeyValueDialect(t *testing.T) {
+	src := "title = hello\n作者＝ボブ\nmemo =\nfirst\n  a = b\n\\c = d\n\nsecond\nlog = abc(2019/1/2)\n"
+	doc := parseDialect(t, src, KeyValueDialect)
+	if len(doc.SectionList) != 4 {
+		t.Fatal("Document.SectionList", doc.SectionList)
+	}
+	if doc.Sections["title"].PeekString() != "hello" || doc.Sections["作者"].PeekString() != "ボブ" {
+		t.Error("Document.Sections", doc.Sections)
+	}
+	memo := doc.Sections["memo"]
+	if len(memo.Value) != 2 || memo.Value[0].String() != `first\na = b\nc = d` || memo.Value[1].LineOf(0) != 8 {
+		t.Error("memo", memo.Value)
+	}
+	if span := doc.Sections["log"].NameSpan; span.Start.Column != 1 || span.End.Column != 4 {
+		t.Error("NameSpan", span)
+	}
+
+	// 字下げした行も値では行頭の空白が除かれるので、書き直すとエスケープされる。
+	memo.Value[0].Value = append(memo.Value[0].Value, "e = f", "=g")
+	doc.Sections["new"] = NewTextSection("world")
+	expected := "title = hello\n作者＝ボブ\nmemo =\nfirst\n\\a = b\n\\c = d\n\\e = f\n=g\n\nsecond\nlog = abc(2019/1/2)\nnew = world\n"
+	if actual := writeString(t, doc); actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestBracketDialect(t *testing.T) {
+	src := "【title】hello\n【env】\n【【os】Windows\n@memo: 【a】\n"
+	doc := parseDialect(t, src, BracketDialect)
+	os := doc.Sections["env"].Children["os"]
+	if doc.Sections["title"].PeekString() != "hello" || len(doc.Sections["env"].Value) != 0 {
+		t.Error("Document.Sections", doc.Sections)
+	}
+	if os.Value[0].String() != `Windows\n@memo: 【a】` {
+		t.Error("os", os.Value[0])
+	}
+
+	doc.Sections["env"].Children["browser"] = NewTextSection("Edge")
+	expected := src + "【【browser】 Edge\n"
+	if actual := writeString(t, doc); actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestDialectErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		d   Dialect
+		err error
+	}{
+		{"hello\n", KeyValueDialect, ErrorNoSectionNameSuffix},
+		{" = hello\n", KeyValueDialect, ErrorSectionNameIsEmpty},
+		{"@title: hello\n", BracketDialect, ErrorNoSectionNamePrefix},
+		{"【title hello\n", BracketDialect, ErrorNoSectionNameSuffix},
+	}
+	for _, tt := range tests {
+		var doc Document
+		err := ParseDocumentWithOptions("test", bytes.NewBufferString(tt.src), &doc, &ParseOptions{Dialect: tt.d})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: %v", tt.src, err)
+		}
+	}
+}
+
+func TestLookupDialect(t *testing.T) {
+	d, ok := LookupDialect("KeyValue")
+	if !ok || d.Suffixes[0] != "=" {
+		t.Error("keyvalue", d)
+	}
+	if _, ok := LookupDialect("ini"); ok {
+		t.Error("ini")
+	}
+}
@@ -0,0 +1,70 @@
+package dptxt
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// 作業量の単位。長いものから順に比べる。日と週の長さは1日の作業時間から求める。
+var durationUnits = []struct {
+	unit  string
+	hours int64 // 1時間を1とした長さ。0なら分
+	days  int64 // 1日を1とした長さ
+}{
+	{"週間", 0, 5},
+	{"時間", 1, 0},
+	{"min", 0, 0},
+	{"週", 0, 5},
+	{"日", 0, 1},
+	{"分", 0, 0},
+	{"w", 0, 5},
+	{"d", 0, 1},
+	{"h", 1, 0},
+}
+
+// ParseDuration 「3h」「2.5d」「1週間」「1日4時間」のような作業量を解析する。dayは1日の作業時間で、1週は5日とする。
+// 単位はw(週、週間)、d(日)、h(時間)、min(分)。月と紛らわしいので、mだけでは分とみなさない。数値と単位の組を続けて書くと、それらの和になる。
+// time.Durationの範囲を超える作業量はErrorNumberOutOfRangeになる。
+func ParseDuration(b string, day time.Duration) (time.Duration, error) {
+	b = strings.TrimFunc(b, isSp)
+	if len(b) == 0 {
+		return 0, ErrorInvalidDuration
+	}
+	var total time.Duration
+	for len(b) > 0 {
+		d, rest, digits, err := decodeDecimal(b)
+		if err != nil || digits == 0 {
+			return 0, ErrorInvalidDuration
+		}
+		rest = strings.TrimLeftFunc(rest, isSp)
+		found := false
+		for _, u := range durationUnits {
+			if len(rest) < len(u.unit) || !strings.EqualFold(rest[:len(u.unit)], u.unit) {
+				continue
+			}
+			unit := time.Minute
+			switch {
+			case u.hours > 0:
+				unit = time.Duration(u.hours) * time.Hour
+			case u.days > 0:
+				unit = time.Duration(u.days) * day
+			}
+			if time.Duration(d.Value) > math.MaxInt64/unit {
+				return 0, ErrorNumberOutOfRange
+			}
+			v := time.Duration(d.Value) * unit / time.Duration(pow10(d.Scale))
+			if total > math.MaxInt64-v {
+				return 0, ErrorNumberOutOfRange
+			}
+			total += v
+			b = strings.TrimLeftFunc(rest[len(u.unit):], isSp)
+			found = true
+			break
+		}
+		if !found {
+			return 0, ErrorInvalidDuration
+		}
+	}
+	return total, nil
+}
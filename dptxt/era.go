@@ -0,0 +1,90 @@
+package dptxt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// era 元号
+type era struct {
+	name    string
+	letters string // 略記に使うアルファベット
+	year    int    // 元年の西暦
+	month   int    // 元号が始まった月
+	day     int    // 元号が始まった日
+}
+
+// 新しい順に並べる。
+var eras = []era{
+	{"令和", "RrＲｒ", 2019, 5, 1},
+	{"平成", "HhＨｈ", 1989, 1, 8},
+	{"昭和", "SsＳｓ", 1926, 12, 25},
+}
+
+// dateKey 日付を前後を比べられる整数にする。
+func dateKey(year, month, day int) int {
+	return year*10000 + month*100 + day
+}
+
+// inEra 日付がi番目の元号の初日から最終日までの間にあるかどうか。最終日は次の元号の初日の前日になる。
+func inEra(i int, year, month, day int) bool {
+	d := dateKey(year, month, day)
+	e := eras[i]
+	if d < dateKey(e.year, e.month, e.day) {
+		return false
+	}
+	if i > 0 {
+		n := eras[i-1]
+		return d < dateKey(n.year, n.month, n.day)
+	}
+	return true
+}
+
+// parseEraYear bの先頭が「令和5」「平成元」「R5」のような元号で始まる年なら、元号のerasの中の位置と、
+// 西暦の年と年の後ろの文字列を返す。元号で始まらなければ位置は-1になる。
+// エラーの場合は誤りが見つかった位置からの文字列を返す。
+func parseEraYear(b string) (int, int, string, error) {
+	for i, e := range eras {
+		var rest string
+		if strings.HasPrefix(b, e.name) {
+			rest = b[len(e.name):]
+		} else if r, s := utf8.DecodeRuneInString(b); strings.ContainsRune(e.letters, r) {
+			rest = b[s:]
+			// 略記はアルファベットの直後に年が続く場合だけ元号とみなす。
+			if _, d, _ := DecodeSingleDigit(rest); d < 0 && !strings.HasPrefix(rest, "元") {
+				continue
+			}
+		} else {
+			continue
+		}
+
+		rest = strings.TrimLeftFunc(rest, isSp)
+		if strings.HasPrefix(rest, "元") {
+			return i, e.year, rest[len("元"):], nil
+		}
+		c, n, r, digits := DecodeDigit(rest)
+		if r == utf8.RuneError {
+			return i, 0, c, ErrorInvalidDateFormat
+		} else if digits == 0 || digits > 2 || n == 0 {
+			return i, 0, rest, ErrorYearIsOutOfRange
+		}
+		return i, e.year + n - 1, c, nil
+	}
+	return -1, 0, b, nil
+}
+
+// FormatEraDate 日付を「令和5年4月1日」のような元号を使った表記にする。元年は「元年」になる。
+// 昭和より前の日付はfalseを返す。
+func FormatEraDate(year, month, day int) (string, bool) {
+	for _, e := range eras {
+		if dateKey(year, month, day) >= dateKey(e.year, e.month, e.day) {
+			y := "元"
+			if n := year - e.year + 1; n > 1 {
+				y = strconv.Itoa(n)
+			}
+			return e.name + y + "年" + strconv.Itoa(month) + "月" + strconv.Itoa(day) + "日", true
+		}
+	}
+	return empty, false
+}
@@ -0,0 +1,85 @@
+package dptxt
+
+import (
+	"testing"
+)
+
+func TestParseEraDate(t *testing.T) {
+	tests := map[string][3]int{
+		"令和5年4月1日":    {2023, 4, 1},
+		"令和元年5月1日":    {2019, 5, 1},
+		"平成 31年4月30日": {2019, 4, 30},
+		"昭和６４年１月７日":   {1989, 1, 7},
+		"R5.4.1":      {2023, 4, 1},
+		"h元/1/8":      {1989, 1, 8},
+		"Ｓ50-10-1":    {1975, 10, 1},
+		"平成31年4月30日":  {2019, 4, 30},
+		"昭和元年12月25日":  {1926, 12, 25},
+	}
+	for src, expected := range tests {
+		year, month, day, post, err := ParseDate(src)
+		if err != nil || [3]int{year, month, day} != expected || len(post) > 0 {
+			t.Error(src, year, month, day, post, err)
+		}
+	}
+}
+
+func TestParseEraDateErr(t *testing.T) {
+	dates := []string{
+		"令和年4月1日",
+		"令和0年4月1日",
+		"令和123年4月1日",
+		"R.4.1",
+		"Rx5.4.1",
+		"大正5年4月1日",
+		// 元号の期間の外
+		"令和元年1月1日",
+		"令和元年4月30日",
+		"平成31年5月1日",
+		"平成元年1月7日",
+		"昭和64年1月8日",
+		"昭和65年1月1日",
+		"昭和元年12月24日",
+	}
+	for _, d := range dates {
+		year, month, day, post, err := ParseDate(d)
+		if err == nil {
+			t.Error(d, year, month, day, post)
+		}
+	}
+}
+
+func TestFormatEraDate(t *testing.T) {
+	tests := []struct {
+		year, month, day int
+		expected         string
+	}{
+		{2023, 4, 1, "令和5年4月1日"},
+		{2019, 5, 1, "令和元年5月1日"},
+		{2019, 4, 30, "平成31年4月30日"},
+		{1989, 1, 7, "昭和64年1月7日"},
+		{1926, 12, 25, "昭和元年12月25日"},
+		{1926, 12, 24, ""},
+	}
+	for _, tt := range tests {
+		if actual, _ := FormatEraDate(tt.year, tt.month, tt.day); actual != tt.expected {
+			t.Error(tt.year, tt.month, tt.day, actual)
+		}
+	}
+}
+
+func TestParseEraDateRange(t *testing.T) {
+	tests := []struct {
+		src        string
+		begin, end int
+	}{
+		{"令和元年4月30日", 0, len("令和元年4月30日")},
+		{" 昭和65年1月1日 ", 1, 1 + len("昭和65年1月1日")},
+	}
+	for _, tt := range tests {
+		_, _, _, _, begin, end, err := ParseDateRange(tt.src)
+		if err != ErrorYearIsOutOfRange || begin != tt.begin || end != tt.end {
+			t.Errorf("%q: %d, %d, %v", tt.src, begin, end, err)
+		}
+	}
+}
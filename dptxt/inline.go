@@ -0,0 +1,192 @@
+package dptxt
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// InlineKind インライン要素の種類
+type InlineKind int
+
+const (
+	InlineText     InlineKind = iota // 普通の文字列
+	InlineCode                       // `code`
+	InlineEmphasis                   // *強調*
+	InlineLink                       // http://またはhttps://で始まるURL
+	InlineRef                        // #issue-3のような他の文書への参照
+)
+
+// Inline パラグラフの一行を解析したインライン要素
+type Inline struct {
+	Kind InlineKind
+	// InlineTextとInlineCodeは文字列、InlineLinkはURL、InlineRefは参照先の文書名(#を除く)
+	Text string
+	// InlineEmphasisの中身
+	Children []*Inline
+}
+
+var urlSchemes = []string{"http://", "https://"}
+
+// isURLRune URLに含まれる文字かどうか
+func isURLRune(r rune) bool {
+	return r > ' ' && r < utf8.RuneSelf && r != '<' && r != '>' && r != '"' && r != '`'
+}
+
+// isRefRune 参照先の文書名に含まれる文字かどうか
+func isRefRune(r rune) bool {
+	return r == '-' || r == '_' || r == '.' || (r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
+
+// isWordRune 直後にURLや参照を置けない文字かどうか。日本語の文中にはそのまま書けるように、英数字だけを対象にする。
+func isWordRune(r rune) bool {
+	return r == '_' || (r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
+
+// scanURL bの先頭がURLならその長さを返す。
+func scanURL(b string) int {
+	for _, s := range urlSchemes {
+		if strings.HasPrefix(b, s) {
+			n := strings.IndexFunc(b, func(r rune) bool { return !isURLRune(r) })
+			if n < 0 {
+				n = len(b)
+			}
+			// 文末の句読点はURLに含めない。
+			n = len(strings.TrimRight(b[:n], ".,;:!?)"))
+			if n > len(s) {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// scanRef bの先頭が#で始まる参照ならその長さを返す。
+func scanRef(b string) int {
+	if len(b) < 2 || b[0] != '#' {
+		return 0
+	}
+	n := strings.IndexFunc(b[1:], func(r rune) bool { return !isRefRune(r) })
+	if n < 0 {
+		n = len(b) - 1
+	}
+	n = len(strings.TrimRight(b[1:1+n], "."))
+	if n == 0 {
+		return 0
+	}
+	return n + 1
+}
+
+// scanEmphasis bの先頭が*で囲まれた強調ならその長さを返す。中身が空白で始まるか終わる場合は強調としない。
+func scanEmphasis(b string) int {
+	if len(b) < 3 || b[0] != '*' {
+		return 0
+	}
+	i := strings.IndexByte(b[1:], '*')
+	if i <= 0 {
+		return 0
+	}
+	inner := b[1 : 1+i]
+	if strings.TrimFunc(inner, isSp) != inner {
+		return 0
+	}
+	return i + 2
+}
+
+// scanCode bの先頭が`で囲まれたコードならその長さを返す。
+func scanCode(b string) int {
+	if len(b) < 3 || b[0] != '`' {
+		return 0
+	}
+	i := strings.IndexByte(b[1:], '`')
+	if i <= 0 {
+		return 0
+	}
+	return i + 2
+}
+
+// ParseInline 一行分の文字列をインライン要素に分解する。
+func ParseInline(b string) []*Inline {
+	nodes := make([]*Inline, 0)
+	text := 0 // まだノードにしていない文字列の始まり
+	prev := ' '
+	addText := func(end int) {
+		if end > text {
+			if len(nodes) > 0 && nodes[len(nodes)-1].Kind == InlineText {
+				nodes[len(nodes)-1].Text += b[text:end]
+			} else {
+				nodes = append(nodes, &Inline{Kind: InlineText, Text: b[text:end]})
+			}
+		}
+	}
+
+	i := 0
+	for i < len(b) {
+		var node *Inline
+		n := 0
+		switch b[i] {
+		case '`':
+			if n = scanCode(b[i:]); n > 0 {
+				node = &Inline{Kind: InlineCode, Text: b[i+1 : i+n-1]}
+			}
+		case '*':
+			if n = scanEmphasis(b[i:]); n > 0 {
+				node = &Inline{Kind: InlineEmphasis, Children: ParseInline(b[i+1 : i+n-1])}
+			}
+		case '#':
+			if !isWordRune(prev) {
+				if n = scanRef(b[i:]); n > 0 {
+					node = &Inline{Kind: InlineRef, Text: b[i+1 : i+n]}
+				}
+			}
+		case 'h':
+			if !isWordRune(prev) {
+				if n = scanURL(b[i:]); n > 0 {
+					node = &Inline{Kind: InlineLink, Text: b[i : i+n]}
+				}
+			}
+		}
+		if node != nil {
+			addText(i)
+			nodes = append(nodes, node)
+			i += n
+			text = i
+			prev, _ = utf8.DecodeLastRuneInString(b[:i])
+			continue
+		}
+		r, s := utf8.DecodeRuneInString(b[i:])
+		prev = r
+		i += s
+	}
+	addText(len(b))
+	return nodes
+}
+
+// PlainText インライン要素を装飾を除いた文字列にする。
+func PlainText(nodes []*Inline) string {
+	var sb strings.Builder
+	for _, n := range nodes {
+		switch n.Kind {
+		case InlineEmphasis:
+			sb.WriteString(PlainText(n.Children))
+		case InlineRef:
+			sb.WriteString("#" + n.Text)
+		default:
+			sb.WriteString(n.Text)
+		}
+	}
+	return sb.String()
+}
+
+// Inlines パラグラフの各行をインライン要素に分解する。コードブロックは解析せずに一行を一つの文字列とする。
+func (p *Paragraph) Inlines() [][]*Inline {
+	lines := make([][]*Inline, len(p.Value))
+	for i, v := range p.Value {
+		if p.Kind == VerbatimParagraph {
+			lines[i] = []*Inline{{Kind: InlineText, Text: v}}
+		} else {
+			lines[i] = ParseInline(v)
+		}
+	}
+	return lines
+}
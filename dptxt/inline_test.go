@@ -0,0 +1,58 @@
+package dptxt
+
+import (
+	"testing"
+)
+
+func inlineString(nodes []*Inline) string {
+	s := ""
+	for _, n := range nodes {
+		switch n.Kind {
+		case InlineText:
+			s += "T(" + n.Text + ")"
+		case InlineCode:
+			s += "C(" + n.Text + ")"
+		case InlineEmphasis:
+			s += "E(" + inlineString(n.Children) + ")"
+		case InlineLink:
+			s += "L(" + n.Text + ")"
+		case InlineRef:
+			s += "R(" + n.Text + ")"
+		}
+	}
+	return s
+}
+
+func TestParseInline(t *testing.T) {
+	tests := map[string]string{
+		"":      "",
+		"hello": "T(hello)",
+		"詳細はhttps://example.com/a?b=1を参照。": "T(詳細は)L(https://example.com/a?b=1)T(を参照。)",
+		"see https://example.com/x.":       "T(see )L(https://example.com/x)T(.)",
+		"(http://example.com)":             "T(()L(http://example.com)T())",
+		"`go vet`を実行":                      "C(go vet)T(を実行)",
+		"*とても* 重要":                         "E(T(とても))T( 重要)",
+		"*see #issue-3*":                   "E(T(see )R(issue-3))",
+		"#issue-3 と #issue-5.":             "R(issue-3)T( と )R(issue-5)T(.)",
+		"abc#issue-3 a#b":                  "T(abc#issue-3 a#b)",
+		"5 * 3 * 2":                        "T(5 * 3 * 2)",
+		"`, **, #, http://":                "T(`, **, #, http://)",
+		"課題#issue-3を参照":                    "T(課題)R(issue-3)T(を参照)",
+		"`*not emphasis*` *`code`*":        "C(*not emphasis*)T( )E(C(code))",
+		"mailto:foo@example.com chttps://": "T(mailto:foo@example.com chttps://)",
+	}
+	for src, expected := range tests {
+		actual := inlineString(ParseInline(src))
+		if actual != expected {
+			t.Errorf("%q\nexpected: %s\nactual:   %s", src, expected, actual)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	src := "*重要* `x` を #issue-3 と https://example.com で確認"
+	expected := "重要 x を #issue-3 と https://example.com で確認"
+	if actual := PlainText(ParseInline(src)); actual != expected {
+		t.Error(actual)
+	}
+}
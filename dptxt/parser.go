@@ -2,9 +2,11 @@ package dptxt
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,11 +32,78 @@ var (
 	ErrorNoOpenParenthesis            = errors.New("日付を指定してください。")
 	ErrorNoCloseParenthesis           = errors.New("日付を指定してください。")
 	ErrorExtraTextAfterDate           = errors.New("日付を指定してください。")
+	ErrorDuplicateSection             = errors.New("セクション名が重複しています。")
+	ErrorUnclosedVerbatim             = errors.New("コードブロックが閉じられていません。")
+	ErrorNoParentSection              = errors.New("親セクションがありません。")
+	ErrorInvalidTimeFormat            = errors.New("時刻の書式に誤りがあります。")
+	ErrorNotRelativeDate              = errors.New("相対的な日付ではありません。")
+	ErrorLineTooLong                  = errors.New("行が長すぎます。")
+	ErrorInvalidNumber                = errors.New("数値の書式に誤りがあります。")
+	ErrorInvalidDuration              = errors.New("期間の書式に誤りがあります。")
+	ErrorNumberOutOfRange             = errors.New("数値が大きすぎます。")
 )
 
+// DuplicatePolicy 同じ名前のセクションが複数あった場合の扱い
+type DuplicatePolicy int
+
+const (
+	DuplicateLast  DuplicatePolicy = iota // 最後のセクションを使う
+	DuplicateError                        // エラーにする
+	DuplicateMerge                        // パラグラフを結合して一つのセクションにする
+)
+
+// ParseOptions パーサの動作を指定する構造体。ゼロ値はParseDocumentの既定の動作になる。
+type ParseOptions struct {
+	Duplicate DuplicatePolicy
+	// 行頭がこれらの文字列で始まる行はコメントとして読み飛ばす。コードブロックの中は対象外。
+	CommentPrefixes []string
+	// trueの場合、エラーがあっても次のセクションまで読み飛ばして読み込みを続ける。
+	// 見つかったエラーはParseErrorsとしてDocument.Errorに格納する。
+	Lenient bool
+	// 0より大きければ、改行文字を除いた長さがこのバイト数を超える行をErrorLineTooLongのエラーにする。0なら制限しない。
+	MaxLineLength int
+	// セクション名の行の書き方。ゼロ値なら「@name:」の形式になる。
+	Dialect Dialect
+}
+
+// ParseErrors 寛容モードで読み込んだときに見つかったエラーの一覧
+type ParseErrors []*ParseError
+
+func (pe ParseErrors) Error() string {
+	msgs := make([]string, len(pe))
+	for i, e := range pe {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// DuplicateSectionError セクション名の重複を表わすエラー
+type DuplicateSectionError struct {
+	Name        string
+	Linenum     int // 後から現われたセクションの行番号
+	PrevLinenum int // 先に現われたセクションの行番号
+	Policy      DuplicatePolicy
+}
+
+func (de *DuplicateSectionError) Error() string {
+	msg := "セクション名「" + de.Name + "」が重複しています(" + strconv.Itoa(de.PrevLinenum) + "行目、" + strconv.Itoa(de.Linenum) + "行目)。"
+	switch de.Policy {
+	case DuplicateLast:
+		msg += "後のセクションを使います。"
+	case DuplicateMerge:
+		msg += "セクションを結合しました。"
+	}
+	return msg
+}
+
+func (de *DuplicateSectionError) Unwrap() error {
+	return ErrorDuplicateSection
+}
+
 type ParseError struct {
 	Filename string
 	Line     int
+	Span     Span // エラーの範囲。範囲が分からない場合はゼロ値になる。
 	Err      error
 }
 
@@ -54,6 +123,13 @@ func NewParseError(filename string, linenum int, err error) *ParseError {
 	return pe
 }
 
+// NewParseErrorAt 範囲を指定してエラーを生成する。行番号は範囲の始まりの行になる。
+func NewParseErrorAt(filename string, span Span, err error) *ParseError {
+	pe := NewParseError(filename, span.Start.Line, err)
+	pe.Span = span
+	return pe
+}
+
 const empty = ""
 
 var emptyBytes []byte = make([]byte, 0, 0)
@@ -65,11 +141,8 @@ func isSp(r rune) bool {
 	return false
 }
 
-func isAt(r rune) bool {
-	if r == '@' || r == '＠' {
-		return true
-	}
-	return false
+func isBackslash(r rune) bool {
+	return r == '\\'
 }
 
 func isColon(r rune) bool {
@@ -94,33 +167,93 @@ func isCloseParenthesis(r rune) bool {
 }
 
 type Document struct {
-	Filename string
-	Sections map[string]*Section
-	Error    error
+	Filename    string
+	Sections    map[string]*Section
+	SectionList []*Section // 文書に現われた順のセクション。重複したセクションも含む。
+	Error       error
+	lead        []string // 最初のセクションより前の空白行(改行文字を含む)
+	tail        []string // エラーのために読み飛ばした最後のセクションより後ろの行(改行文字を含む)
+	eol         string   // 文書で使われている改行文字
+	dialect     *Dialect // 文書を読み込んだときのセクション名の行の書き方
 }
 
 type Section struct {
+	Name        string
 	Linenum     int
 	Value       []*Paragraph
 	peekedValue string
 	Error       error
-	Expired     bool
-	Time        *time.Time
-	Number      int64
+	Expired     bool                // Deprecated: Dataを使う。互換性のために、dpshはDataと同じ値も設定する。
+	Time        *time.Time          // Deprecated: Dataを使う。
+	Number      int64               // Deprecated: Dataを使う。
+	Data        interface{}         // 値を解析した結果。解析の仕方と格納する値は使う側が決める。
+	Derived     bool                // 文書に書かれておらず、既定値などから作られたセクションか
+	Span        Span                // セクション名の行の始まりから最後のパラグラフの終わりまでの範囲。子セクションは含まない。
+	NameSpan    Span                // セクション名の範囲
+	Children    map[string]*Section // 子セクション
+	ChildList   []*Section          // 文書に現われた順の子セクション。重複したセクションも含む。
+	depth       int                 // セクション名プリフィックスの数。子セクションは親より一つ多い。
+	name        string              // 読み込んだときのセクション名
+	pre         []string            // セクション名の前の空白行やエラーのために読み飛ばした行(改行文字を含む)
+	header      string              // 読み込んだときのセクション名の行(inlineの場合は値の直前まで)
+	inline      bool                // 最初のパラグラフがセクション名と同じ行から始まっているか
+	trail       []string            // 最後のパラグラフの後ろの空白行(改行文字を含む)
+	parts       []*Section          // 重複したセクションを結合した場合の、結合する前のセクション
 }
 
+// ParagraphKind パラグラフの種類
+type ParagraphKind int
+
+const (
+	TextParagraph     ParagraphKind = iota // 通常のパラグラフ
+	VerbatimParagraph                      // ```で囲まれた、入力のままのパラグラフ
+)
+
 type Paragraph struct {
 	Linenum    int
+	Kind       ParagraphKind
+	Info       string // VerbatimParagraphの開始の```に続く文字列
 	Value      []string
 	Error      error
 	Time       *time.Time
+	HasClock   bool // Timeに時刻が指定されているか
 	TimeSuffix string
+	Span       Span       // パラグラフの範囲。コードブロックは開始と終了の```を含む。
+	starts     []Position // Valueの各行の始まりの位置
+	pre        []string   // パラグラフの前の空白行(改行文字を含む)
+	raw        []string   // 読み込んだときの行(改行文字を含む)
+	orig       []string   // 読み込んだときのValueの複製
 }
 
 func (p *Paragraph) String() string {
 	return strings.Join(p.Value, `\n`)
 }
 
+// LineOf Value[i]の行番号を返す。ParseDocumentで読み込んだパラグラフでなければ-1を返す。
+func (p *Paragraph) LineOf(i int) int {
+	if p.Linenum < 0 {
+		return -1
+	}
+	if i < len(p.starts) {
+		return p.starts[i].Line
+	}
+	return p.Linenum + i
+}
+
+// PositionOf Value[i]のbバイト目の入力の中の位置を返す。
+// ParseDocumentで読み込んだままのパラグラフでなければ、無効な位置を返す。
+func (p *Paragraph) PositionOf(i int, b int) Position {
+	if i < 0 || i >= len(p.starts) || p.modified() || b < 0 || b > len(p.Value[i]) {
+		return Position{}
+	}
+	return p.starts[i].Advance(p.Value[i][:b])
+}
+
+// SpanOf Value[i]のbegin-endバイト目の入力の中の範囲を返す。
+func (p *Paragraph) SpanOf(i int, begin int, end int) Span {
+	return Span{p.PositionOf(i, begin), p.PositionOf(i, end)}
+}
+
 func NewTextParagraph(t string) *Paragraph {
 	ps := append(make([]string, 0), t)
 	return &Paragraph{Linenum: -1, Value: ps}
@@ -137,10 +270,116 @@ func (s *Section) String() string {
 func (d *Document) String() string {
 	buf := make([]string, 0, len(d.Sections)+1)
 	buf = append(buf, d.Filename)
-	for n, v := range d.Sections {
-		buf = append(buf, "\""+n+"\":"+v.String())
+	return strings.Join(appendSectionStrings(buf, empty, d.orderedSections()), ",")
+}
+
+func appendSectionStrings(buf []string, parent string, secs []namedSection) []string {
+	for _, v := range secs {
+		buf = append(buf, "\""+parent+v.Name+"\":"+v.String())
+		buf = appendSectionStrings(buf, parent+v.Name+"/", v.orderedChildren())
+	}
+	return buf
+}
+
+type namedSection struct {
+	Name string
+	*Section
+}
+
+// orderSections セクションを文書に現われた順に返す。
+// Sectionsから削除されたセクションは除き、Sectionsで置き換えられたセクションは同じ名前の最後のセクションの位置に置く。
+// 結合したセクションが変更されていなければ、結合する前のセクションをそれぞれの位置に置く。
+// SectionListにないセクションは末尾に名前順で並べる。
+func orderSections(sections map[string]*Section, list []*Section) []namedSection {
+	listed := make(map[*Section]bool, len(list))
+	last := make(map[string]int, len(list))
+	for i, s := range list {
+		listed[s] = true
+		last[s.Name] = i
+	}
+	secs := make([]namedSection, 0, len(list))
+	for i, s := range list {
+		cur, ok := sections[s.Name]
+		if !ok {
+			continue
+		}
+		if listed[cur] || cur.mergedIntact() {
+			secs = append(secs, namedSection{s.Name, s})
+		} else if last[s.Name] == i {
+			secs = append(secs, namedSection{s.Name, cur})
+		}
+	}
+	names := make([]string, 0)
+	for n := range sections {
+		if _, ok := last[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		secs = append(secs, namedSection{n, sections[n]})
+	}
+	return secs
+}
+
+func (d *Document) orderedSections() []namedSection {
+	return orderSections(d.Sections, d.SectionList)
+}
+
+func (s *Section) orderedChildren() []namedSection {
+	return orderSections(s.Children, s.ChildList)
+}
+
+// Lookup 名前かパスでセクションを探す。パスは「env/os」のように親と子のセクション名を/で区切る。
+// 見つからなければnilを返す。
+func (d *Document) Lookup(path string) *Section {
+	if sec, ok := d.Sections[path]; ok {
+		return sec
+	}
+	var sec *Section
+	secs := d.Sections
+	for _, n := range strings.Split(path, "/") {
+		n, err := normalizeText(n)
+		if err != nil {
+			return nil
+		}
+		if sec = secs[n]; sec == nil {
+			return nil
+		}
+		secs = sec.Children
+	}
+	return sec
+}
+
+// SetSection nameという名前のセクションを追加、または置き換える。
+// 置き換えた場合、セクションの順序は元のセクションの位置になる。
+func (d *Document) SetSection(name string, sec *Section) {
+	if d.Sections == nil {
+		d.Sections = make(map[string]*Section)
+	}
+	sec.Name = name
+	if old, ok := d.Sections[name]; ok {
+		for i, s := range d.SectionList {
+			if s == old {
+				d.SectionList[i] = sec
+			}
+		}
+	} else {
+		d.SectionList = append(d.SectionList, sec)
+	}
+	d.Sections[name] = sec
+}
+
+// RemoveSection nameという名前のセクションを削除する。重複したセクションもすべて削除する。
+func (d *Document) RemoveSection(name string) {
+	delete(d.Sections, name)
+	list := d.SectionList[:0]
+	for _, s := range d.SectionList {
+		if s.Name != name {
+			list = append(list, s)
+		}
 	}
-	return strings.Join(buf, ",")
+	d.SectionList = list
 }
 
 func NewTextSection(t string) *Section {
@@ -171,15 +410,148 @@ func (s *Section) PeekString() string {
 // }
 
 type lineScanner struct {
-	scanner  *bufio.Scanner
+	reader   *bufio.Reader
+	pending  string // 読み込んだが、まだ行に切り分けていない文字列
+	err      error  // 読み込みで発生したエラー。一度エラーになったら以降も同じエラーを返す。
+	opts     *ParseOptions
+	dialect  *Dialect
 	lastline string
+	lasteol  string
+	offset   int    // lastlineの始まりの入力の先頭からのバイト数
+	next     int    // 次の行の始まりの入力の先頭からのバイト数
+	eol      string // 最初に見つかった改行文字
+	tooLong  bool   // 長すぎる行の読み込みを途中でやめたか
 	unread   bool
+	errors   ParseErrors // 寛容モードで見つかったエラー
 	Filename string
 	Linenum  int
 }
 
-func newLineScanner(filename string, r io.Reader) *lineScanner {
-	return &lineScanner{bufio.NewScanner(r), "", false, filename, 0}
+func newLineScanner(filename string, r io.Reader, opts *ParseOptions) *lineScanner {
+	dialect := *opts.Dialect.orDefault()
+	return &lineScanner{reader: bufio.NewReader(r), opts: opts, dialect: &dialect, Filename: filename}
+}
+
+// posAt 最後に読んだ行のbバイト目の位置を返す。
+func (ls *lineScanner) posAt(b int) Position {
+	return Position{ls.Linenum, utf8.RuneCountInString(ls.lastline[:b]) + 1, b + 1, ls.offset + b}
+}
+
+// spanAt 最後に読んだ行のbegin-endバイト目の範囲を返す。
+func (ls *lineScanner) spanAt(begin int, end int) Span {
+	return Span{ls.posAt(begin), ls.posAt(end)}
+}
+
+// valueAt 最後に読んだ行のstartバイト目から始まる値vのエスケープを外し、値の始まりの位置と一緒に返す。
+func (ls *lineScanner) valueAt(v string, start int) (string, Position) {
+	u := ls.dialect.unescape(v)
+	return u, ls.posAt(start + len(v) - len(u))
+}
+
+// contentEnd 最後に読んだ行の末尾の空白を除いた終わりのバイト数を返す。
+func (ls *lineScanner) contentEnd() int {
+	return len(strings.TrimRightFunc(ls.lastline, isSp))
+}
+
+// addError 寛容モードで見つかったエラーを記録する。
+func (ls *lineScanner) addError(err error) {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		pe = ls.NewParseError(err)
+	}
+	log.Println(pe)
+	ls.errors = append(ls.errors, pe)
+}
+
+// skipToNextSection 次のセクション名の行の手前まで読み飛ばす。読み飛ばした行を改行文字を含めて返す。
+func (ls *lineScanner) skipToNextSection() []string {
+	var skipped []string
+	line, err := ls.nextLine()
+	for err == nil {
+		if ls.dialect.isHeader(line) && !ls.isComment() {
+			ls.UnreadLine()
+			break
+		}
+		skipped = append(skipped, ls.rawLine())
+		line, err = ls.nextLine()
+	}
+	return skipped
+}
+
+// isComment 最後に読んだ行がコメントかどうか
+func (ls *lineScanner) isComment() bool {
+	for _, c := range ls.opts.CommentPrefixes {
+		if len(c) > 0 && strings.HasPrefix(ls.lastline, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// readChunk LFまでを読み込む。行の長さに制限がある場合、最後のCRより後ろが制限を超えたら読み込みをやめてエラーにする。
+// CRで終わる行が先にあれば、それらの行を返してから次の呼び出しでエラーにする。
+func (ls *lineScanner) readChunk() (string, error) {
+	if ls.tooLong {
+		return empty, ErrorLineTooLong
+	}
+	max := ls.opts.MaxLineLength
+	var buf []byte
+	for {
+		frag, err := ls.reader.ReadSlice('\n')
+		buf = append(buf, frag...)
+		if err != bufio.ErrBufferFull {
+			return string(buf), err
+		}
+		// CRで終わる行はreadLineで長さを確かめるので、読み込み途中の最後の行だけを確かめる。
+		cr := bytes.LastIndexByte(buf, '\r')
+		if max > 0 && len(buf)-(cr+1) > max {
+			if cr < 0 {
+				return empty, ErrorLineTooLong
+			}
+			ls.tooLong = true
+			return string(buf[:cr+1]), nil
+		}
+	}
+}
+
+// readLine 次の一行を、行末の改行文字と分けて返す。改行文字はCRLF、LF、CRのいずれか。
+// 最後の行が改行文字で終わっていなければ、改行文字は空になる。
+func (ls *lineScanner) readLine() (string, string, error) {
+	if len(ls.pending) == 0 {
+		chunk, err := ls.readChunk()
+		if len(chunk) == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return empty, empty, err
+		}
+		if err != nil && err != io.EOF {
+			return empty, empty, err
+		}
+		ls.pending = chunk
+	}
+
+	var line, eol string
+	p := ls.pending
+	i := strings.IndexAny(p, "\r\n")
+	switch {
+	case i < 0:
+		line, eol = p, empty
+	case strings.HasPrefix(p[i:], "\r\n"):
+		line, eol = p[:i], "\r\n"
+	default:
+		line, eol = p[:i], p[i:i+1]
+	}
+	ls.pending = p[len(line)+len(eol):]
+	if max := ls.opts.MaxLineLength; max > 0 && len(line) > max {
+		return empty, empty, ErrorLineTooLong
+	}
+	return line, eol, nil
+}
+
+// rawLine 最後に読んだ行を改行文字を含めて返す。
+func (ls *lineScanner) rawLine() string {
+	return ls.lastline + ls.lasteol
 }
 
 func (ls *lineScanner) nextLine() (string, error) {
@@ -188,39 +560,47 @@ func (ls *lineScanner) nextLine() (string, error) {
 		ls.Linenum++
 		return ls.lastline, nil
 	}
-	if ls.scanner.Scan() {
-		t := ls.scanner.Text()
-		ls.lastline = t
-		ls.unread = false
-		ls.Linenum++
-		return t, nil
+	if ls.err != nil {
+		return "", ls.err
 	}
-	err := ls.scanner.Err()
-	if err == nil {
-		// 入力の最後まで逹っした場合、s.Scan()はfalseを返すが、
-		// エラーはnilになる。なので、敢えてio.EOFにして非nilな返り値を返すようにする。
-		err = io.EOF
+	t, eol, err := ls.readLine()
+	if err != nil {
+		if err != io.EOF {
+			// エラーの行番号は読み込めなかった行の番号にする。
+			ls.Linenum++
+		}
+		ls.err = err
+		return "", err
 	}
-	return "", err
+	ls.lastline = t
+	ls.lasteol = eol
+	ls.offset = ls.next
+	ls.next += len(t) + len(eol)
+	if len(ls.eol) == 0 {
+		ls.eol = eol
+	}
+	ls.unread = false
+	ls.Linenum++
+	return t, nil
 }
 
-// 空白行を読み飛ばす。
-func (ls *lineScanner) SkipEmptyLines() (int, error) {
-	n := 0
+// 空白行とコメント行を読み飛ばす。読み飛ばした行を改行文字を含めて返す。
+func (ls *lineScanner) SkipEmptyLines() ([]string, error) {
+	var skipped []string
 	line, err := ls.nextLine()
 	for err == nil {
 		line = strings.TrimLeftFunc(line, isSp)
-		if len(line) > 0 {
+		if len(line) > 0 && !ls.isComment() {
 			break
 		}
-		n++
+		skipped = append(skipped, ls.rawLine())
 		line, err = ls.nextLine()
 	}
 	if err != nil {
-		return 0, err
+		return skipped, err
 	}
 	ls.UnreadLine()
-	return n, nil
+	return skipped, nil
 }
 
 func (ls *lineScanner) UnreadLine() bool {
@@ -240,108 +620,418 @@ func (ls *lineScanner) NewParseError(err error) *ParseError {
 
 func processSection(ls *lineScanner, sec *Section) (string, error) {
 	// 空白行を読み飛ばす。
-	ls.SkipEmptyLines()
+	pre, _ := ls.SkipEmptyLines()
 	line, err := ls.nextLine()
 	if err != nil {
 		return empty, ls.NewParseError(err)
 	}
 	linenum := ls.Linenum
+	raw := line
 	line = strings.TrimLeftFunc(line, isSp)
+	begin := len(raw) - len(line) // セクション名プリフィックスの位置
+	end := ls.contentEnd()
 
-	// セクション名の始まり
-	i, s := IndexFuncWithSize(line, isAt)
-	if i != 0 {
-		return empty, ls.NewParseError(ErrorNoSectionNamePrefix)
+	// セクション名の始まり。プリフィックスが続く数だけ深い子セクションになる。
+	d := ls.dialect
+	depth := 0
+	for s, ok := d.hasPrefix(line); ok; s, ok = d.hasPrefix(line) {
+		depth++
+		line = line[s:]
+	}
+	if len(d.Prefixes) == 0 {
+		depth = 1
+	} else if depth == 0 {
+		return empty, NewParseErrorAt(ls.Filename, ls.spanAt(begin, end), ErrorNoSectionNamePrefix)
 	}
-	line = line[i+s:]
 
 	// セクション名の終わり
-	i, s = IndexFuncWithSize(line, isColon)
+	i, s := d.indexSuffix(line)
 	if i == -1 { // コロンが見つからない
-		return empty, ls.NewParseError(ErrorNoSectionNameSuffix)
+		return empty, NewParseErrorAt(ls.Filename, ls.spanAt(begin, end), ErrorNoSectionNameSuffix)
 	}
 	name, err := normalizeText(line[:i]) // セクション名を正規化する。
 	if err != nil {
-		return empty, ls.NewParseError(err)
+		return empty, NewParseErrorAt(ls.Filename, ls.spanAt(begin, len(raw)-len(line)+i+s), err)
 	}
+	nameBegin := len(raw) - len(strings.TrimLeftFunc(line, isSp))
+	nameEnd := len(raw) - len(line) + len(strings.TrimRightFunc(line[:i], isSp))
+	nameSpan := ls.spanAt(nameBegin, nameEnd)
 	line = line[i+s:]
 
 	// セクション本文の始まり
-	var head string
-	line = strings.TrimFunc(line, isSp)
+	var (
+		head    string
+		headPos Position
+	)
+	rest := strings.TrimLeftFunc(line, isSp)
+	header := raw[:len(raw)-len(rest)]
+	line = strings.TrimRightFunc(rest, isSp)
 	if len(line) > 0 {
-		head = line
+		head, headPos = ls.valueAt(line, len(header))
+	} else {
+		header = ls.rawLine()
 	}
-	ps, err := readCompoundValues(ls, head)
+	headRaw := rest + ls.lasteol
+	span := ls.spanAt(begin, end)
+	ps, trail, err := readCompoundValues(ls, head, headRaw, headPos)
 	if err != nil {
-		return empty, ls.NewParseError(err)
+		return empty, err
 	}
-	*sec = Section{Linenum: linenum, Value: ps, peekedValue: empty}
+	if len(ps) > 0 {
+		span.End = ps[len(ps)-1].Span.End
+	}
+	*sec = Section{Linenum: linenum, Value: ps, peekedValue: empty, Name: name, Span: span, NameSpan: nameSpan, depth: depth, name: name, pre: pre, header: header, inline: len(head) > 0, trail: trail}
 	return name, nil
 }
 
-func readCompoundValues(ls *lineScanner, head string) ([]*Paragraph, error) {
+func newParagraph(starts []Position, pre []string, raw []string, value []string) *Paragraph {
+	orig := make([]string, len(value))
+	copy(orig, value)
+	p := &Paragraph{Value: value, starts: starts, pre: pre, raw: raw, orig: orig}
+	if len(starts) > 0 {
+		last := len(starts) - 1
+		p.Linenum = starts[0].Line
+		p.Span = Span{starts[0], starts[last].Advance(value[last])}
+	}
+	return p
+}
+
+// openFence 行がコードブロックの開始ならば、開始の```と```に続く文字列を返す。
+func openFence(line string) (string, string, bool) {
+	n := 0
+	for n < len(line) && line[n] == '`' {
+		n++
+	}
+	if n < 3 {
+		return empty, empty, false
+	}
+	return line[:n], strings.TrimFunc(line[n:], isSp), true
+}
+
+// closeFence 行がfenceで始まったコードブロックの終わりかどうかを返す。
+func closeFence(line string, fence string) bool {
+	line = strings.TrimFunc(line, isSp)
+	return len(line) >= len(fence) && len(strings.TrimLeft(line, "`")) == 0
+}
+
+// readVerbatim コードブロックの終わりまでを、空白や空白行も含めて入力のまま読み込む。openは開始の```の行の範囲。
+func readVerbatim(ls *lineScanner, fence string, info string, openRaw string, open Span) (*Paragraph, error) {
+	linenum := ls.Linenum
+	raw := []string{openRaw}
+	value := make([]string, 0)
+	starts := make([]Position, 0)
+	verbatim := func() *Paragraph {
+		p := newParagraph(starts, nil, raw, value)
+		p.Linenum = linenum + 1
+		p.Kind = VerbatimParagraph
+		p.Info = info
+		p.Span = Span{open.Start, ls.posAt(ls.contentEnd())}
+		return p
+	}
+	line, err := ls.nextLine()
+	for err == nil {
+		raw = append(raw, ls.rawLine())
+		if closeFence(line, fence) {
+			return verbatim(), nil
+		}
+		value = append(value, line)
+		starts = append(starts, ls.posAt(0))
+		line, err = ls.nextLine()
+	}
+	if err == io.EOF {
+		pe := NewParseErrorAt(ls.Filename, open, ErrorUnclosedVerbatim)
+		if ls.opts.Lenient {
+			// 寛容モードでは文書の最後までをコードブロックとする。
+			ls.addError(pe)
+			p := verbatim()
+			p.Error = pe
+			return p, nil
+		}
+		return nil, pe
+	}
+	return nil, NewParseError(ls.Filename, linenum, err)
+}
+
+// readCompoundValues セクションの値を読み込む。最後のパラグラフの後ろの空白行も返す。
+// headPosはセクション名と同じ行にある値の始まりの位置。
+func readCompoundValues(ls *lineScanner, head string, headRaw string, headPos Position) ([]*Paragraph, []string, error) {
 	values := make([]*Paragraph, 0)
 	pvalues := make([]string, 0)
-	var linenum int
-	if len(head) > 0 {
-		linenum = ls.Linenum
+	var praw, blanks []string
+	var starts []Position
+
+	// 読み込み途中のパラグラフを確定する。
+	flush := func() {
+		if len(pvalues) > 0 {
+			values = append(values, newParagraph(starts, blanks, praw, pvalues))
+			pvalues = make([]string, 0)
+			praw = nil
+			blanks = nil
+			starts = nil
+		}
+	}
+
+	if fence, info, ok := openFence(head); ok {
+		p, err := readVerbatim(ls, fence, info, headRaw, lineSpan(headPos, head))
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, p)
+	} else if len(head) > 0 {
+		starts = append(starts, headPos)
 		pvalues = append(pvalues, head)
+		praw = append(praw, headRaw)
 	}
 
 	line, err := ls.nextLine()
 	for err == nil {
+		start := len(line) - len(strings.TrimLeftFunc(line, isSp))
 		line = strings.TrimFunc(line, isSp)
-		if len(line) > 0 {
-			if strings.IndexFunc(line, isAt) == 0 { // 次のセクションまで来た。
+		if ls.isComment() {
+			// コメントはパラグラフを区切らない。書き戻すときのために行だけ取っておく。
+			if len(pvalues) > 0 {
+				praw = append(praw, ls.rawLine())
+			} else {
+				blanks = append(blanks, ls.rawLine())
+			}
+		} else if len(line) > 0 {
+			if ls.dialect.isHeader(ls.lastline) { // 次のセクションまで来た。
 				ls.UnreadLine()
 				break
-			} else {
-				if len(pvalues) == 0 {
-					linenum = ls.Linenum
+			} else if fence, info, ok := openFence(line); ok {
+				flush()
+				p, err := readVerbatim(ls, fence, info, ls.rawLine(), ls.spanAt(start, start+len(line)))
+				if err != nil {
+					return nil, nil, err
 				}
-				pvalues = append(pvalues, line)
+				p.pre = blanks
+				blanks = nil
+				values = append(values, p)
+			} else {
+				v, pos := ls.valueAt(line, start)
+				starts = append(starts, pos)
+				pvalues = append(pvalues, v)
+				praw = append(praw, ls.rawLine())
 			}
 		} else {
-			if len(pvalues) > 0 {
-				values = append(values, &Paragraph{Linenum: linenum, Value: pvalues})
-				pvalues = make([]string, 0)
-			}
+			flush()
+			blanks = append(blanks, ls.rawLine())
 		}
 		line, err = ls.nextLine()
 	}
 
 	// io.EOFはファイルの末尾なのでエラー扱いにしない。
-	if err != nil && err != io.EOF {
-		return nil, ls.NewParseError(err)
+	// 寛容モードでは読み込めた分までをセクションの値とし、エラーは次のセクションを読むときに返す。
+	if err != nil && err != io.EOF && !ls.opts.Lenient {
+		return nil, nil, ls.NewParseError(err)
 	}
 
-	if len(pvalues) > 0 {
-		values = append(values, &Paragraph{Linenum: linenum, Value: pvalues})
-	}
-	return values, nil
+	flush()
+	return values, blanks, nil
 }
 
+// ParseDocument 既定の設定でrからdptxt形式の文書を読み込み、docに格納する。
 func ParseDocument(filename string, r io.Reader, doc *Document) error {
-	ls := newLineScanner(filename, r)
+	return ParseDocumentWithOptions(filename, r, doc, nil)
+}
 
-	secs := make(map[string]*Section)
-	var sec *Section = new(Section)
-	name, err := processSection(ls, sec)
-	for err == nil {
-		secs[name] = sec
-		sec = new(Section)
-		name, err = processSection(ls, sec)
+// sectionParts 結合したセクションなら結合する前のセクションを、そうでなければ自分自身だけを返す。
+func (s *Section) sectionParts() []*Section {
+	if s.parts != nil {
+		return s.parts
+	}
+	return []*Section{s}
+}
+
+// mergedIntact 結合したセクションが、結合する前のセクションから変更されていないかどうか。
+// 結合したセクションでなければfalseを返す。
+func (s *Section) mergedIntact() bool {
+	if s.parts == nil {
+		return false
 	}
-	if !errors.Is(err, io.EOF) {
-		log.Println(err)
-		return err
+	var values []*Paragraph
+	var children []*Section
+	for _, p := range s.parts {
+		values = append(values, p.Value...)
+		children = append(children, p.ChildList...)
+	}
+	if len(s.Value) != len(values) || len(s.ChildList) != len(children) {
+		return false
+	}
+	for i := range values {
+		if s.Value[i] != values[i] {
+			return false
+		}
+	}
+	for i := range children {
+		if s.ChildList[i] != children[i] {
+			return false
+		}
+	}
+	return sectionsIntact(s.Children, s.ChildList)
+}
+
+// sectionsIntact 子セクションが追加、削除、置き換えされていないかどうか
+func sectionsIntact(sections map[string]*Section, list []*Section) bool {
+	listed := make(map[*Section]bool, len(list))
+	for _, s := range list {
+		if _, ok := sections[s.Name]; !ok {
+			return false
+		}
+		listed[s] = true
+	}
+	for _, cur := range sections {
+		if !listed[cur] && !cur.mergedIntact() {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSections 重複したセクションを結合したセクションを作る。
+// 書き戻すときのために、結合する前のセクションを記録しておく。
+func mergeSections(a, b *Section) *Section {
+	m := *a
+	m.parts = append(append(make([]*Section, 0, len(a.sectionParts())+1), a.sectionParts()...), b)
+	m.Value = make([]*Paragraph, 0, len(a.Value)+len(b.Value))
+	m.Value = append(m.Value, a.Value...)
+	m.Value = append(m.Value, b.Value...)
+	m.peekedValue = empty
+	// 後のセクションの子セクションは結合したセクションに追加されるので、元のセクションと共有しないようにする。
+	m.Children = make(map[string]*Section, len(a.Children))
+	for n, c := range a.Children {
+		m.Children[n] = c
+	}
+	m.ChildList = append(make([]*Section, 0, len(a.ChildList)), a.ChildList...)
+	return &m
+}
+
+// addSection 重複したときの扱いに従ってsecsとlistにセクションを追加する。
+// 追加したセクション(結合した場合は結合後のセクション)と更新したlistを返す。
+func addSection(filename string, secs map[string]*Section, list []*Section, name string, sec *Section, opts *ParseOptions) (*Section, []*Section, error) {
+	list = append(list, sec)
+	if prev, ok := secs[name]; ok {
+		de := &DuplicateSectionError{name, sec.Linenum, prev.Linenum, opts.Duplicate}
+		pe := NewParseErrorAt(filename, sec.NameSpan, de)
+		switch opts.Duplicate {
+		case DuplicateError:
+			// 寛容モードで読み込みを続ける場合のために、listにだけ追加したセクションを返す。
+			return sec, list, pe
+		case DuplicateMerge:
+			sec = mergeSections(prev, sec)
+		}
+		sec.Error = pe
+		log.Println(pe)
+	}
+	secs[name] = sec
+	return sec, list, nil
+}
+
+// ParseDocumentWithOptions optsに従ってrからdptxt形式の文書を読み込み、docに格納する。optsがnilの場合は既定の設定になる。
+func ParseDocumentWithOptions(filename string, r io.Reader, doc *Document, opts *ParseOptions) error {
+	if opts == nil {
+		opts = &ParseOptions{}
+	}
+	ls := newLineScanner(filename, r, opts)
+
+	// 最初のセクションより前の空白行は書き戻すときのために取っておく。
+	lead, _ := ls.SkipEmptyLines()
+
+	secs := make(map[string]*Section)
+	list := make([]*Section, 0)
+	parents := make([]*Section, 0) // 直前のセクションとその祖先
+	raws := make([]*Section, 0)    // parentsのそれぞれの、結合する前のセクション
+	var skipped []string           // エラーのために読み飛ばした行
+	for {
+		sec := new(Section)
+		name, err := processSection(ls, sec)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if !opts.Lenient {
+				log.Println(err)
+				return err
+			}
+			ls.addError(err)
+			if !isSyntaxError(err) {
+				// 入力の読み込み自体に失敗した場合は、それまでに読み込めた分だけにする。
+				break
+			}
+			// セクション名の行に誤りがあった場合は、次のセクションまで読み飛ばす。
+			skipped = append(skipped, ls.rawLine())
+			skipped = append(skipped, ls.skipToNextSection()...)
+			continue
+		}
+		if len(skipped) > 0 {
+			sec.pre = append(skipped, sec.pre...)
+			skipped = nil
+		}
+
+		if sec.depth > len(parents)+1 {
+			err = NewParseErrorAt(filename, sec.NameSpan, ErrorNoParentSection)
+			if !opts.Lenient {
+				log.Println(err)
+				return err
+			}
+			// 親のないセクションは最上位のセクションとして扱う。
+			ls.addError(err)
+			parents = parents[:0]
+			raws = raws[:0]
+		} else {
+			parents = parents[:sec.depth-1]
+			raws = raws[:sec.depth-1]
+		}
+		raw := sec
+		if len(parents) == 0 {
+			sec, list, err = addSection(filename, secs, list, name, sec, opts)
+		} else {
+			p := parents[len(parents)-1]
+			if p.Children == nil {
+				p.Children = make(map[string]*Section)
+			}
+			sec, p.ChildList, err = addSection(filename, p.Children, p.ChildList, name, sec, opts)
+			// 結合したセクションの子セクションは、書き戻すときのために結合する前のセクションにも加える。
+			if r := raws[len(raws)-1]; r != p {
+				if r.Children == nil {
+					r.Children = make(map[string]*Section)
+				}
+				if _, ok := r.Children[name]; !ok {
+					r.Children[name] = raw
+				}
+				r.ChildList = append(r.ChildList, raw)
+			}
+		}
+		if err != nil {
+			if !opts.Lenient {
+				log.Println(err)
+				return err
+			}
+			ls.addError(err)
+		}
+		parents = append(parents, sec)
+		raws = append(raws, raw)
 	}
 	doc.Filename = filename
 	doc.Sections = secs
+	doc.SectionList = list
+	if len(ls.errors) > 0 {
+		doc.Error = ls.errors
+	}
+	doc.lead = lead
+	doc.tail = skipped
+	doc.eol = ls.eol
+	doc.dialect = ls.dialect
 	return nil
 }
 
+// isSyntaxError セクション名の行の書式の誤りかどうか
+func isSyntaxError(err error) bool {
+	return errors.Is(err, ErrorNoSectionNamePrefix) ||
+		errors.Is(err, ErrorNoSectionNameSuffix) ||
+		errors.Is(err, ErrorSectionNameIsEmpty)
+}
+
 func normalizeText(b string) (string, error) {
 	ps := strings.FieldsFunc(b, isSp)
 	if len(ps) == 0 {
@@ -350,6 +1040,13 @@ func normalizeText(b string) (string, error) {
 	return strings.Join(ps, " "), nil
 }
 
+// NormalizeSectionName 文書から読み込んだセクション名と同じように、前後の空白を取り除き、続けて書かれた空白を一つの半角の空白にする。
+// 空白だけなら空文字列を返す。
+func NormalizeSectionName(name string) string {
+	n, _ := normalizeText(name)
+	return n
+}
+
 func IndexFuncWithSize(b string, f func(r rune) bool) (int, int) {
 	i := 0
 	for len(b) > 0 {
@@ -490,21 +1187,58 @@ var monthSuffixes map[rune]rune = map[rune]rune{
 	'．':      '.',
 }
 
+// ParseDate 文字列の先頭の日付を解析する。日付より後ろの部分も返す。
 func ParseDate(b string) (int, int, int, string, error) {
+	year, month, day, post, _, _, err := ParseDateRange(b)
+	return year, month, day, post, err
+}
+
+// ParseDateRange ParseDateと同じように日付を解析し、bの中の日付の範囲をバイト数で返す。
+// エラーの場合は、日付の始まりから誤りが見つかった文字までの範囲を返す。
+func ParseDateRange(b string) (int, int, int, string, int, int, error) {
+	year, month, day, rest, begin, end, err := parseDate(b)
+	if err != nil {
+		return year, month, day, "", begin, end, err
+	}
+	// 日付けの直後の文字がないか、空白でなければエラー
+	r, s := utf8.DecodeRuneInString(rest)
+	if len(rest) == 0 || isSp(r) {
+		return year, month, day, strings.TrimFunc(rest, isSp), begin, end, nil
+	}
+	return year, month, day, "", begin, end + s, ErrorUnknownDateSuffix
+}
+
+// parseDate bの先頭の日付を解析し、日付の直後からの文字列を返す。
+func parseDate(b string) (int, int, int, string, int, int, error) {
 	var (
 		year, month, day       int = 0, 0, 0
 		monthsuffix, daysuffix rune
 		r                      rune
 		n, s                   int
 	)
-
+	src := b
 	b = strings.TrimLeftFunc(b, isSp)
+	begin := len(src) - len(b)
+	fail := func(err error) (int, int, int, string, int, int, error) {
+		_, s := utf8.DecodeRuneInString(b)
+		return year, month, day, "", begin, len(src) - len(b) + s, err
+	}
 
-	b, year, r, n = DecodeDigit(b)
-	if r == utf8.RuneError {
-		return year, month, day, "", ErrorInvalidDateFormat
-	} else if n != 4 {
-		return year, month, day, "", ErrorYearIsOutOfRange
+	// 「令和5年」「R5」のような元号の年は西暦にする。
+	era, y, rest, err := parseEraYear(b)
+	if era >= 0 {
+		b = rest
+		if err != nil {
+			return fail(err)
+		}
+		year = y
+	} else {
+		b, year, r, n = DecodeDigit(b)
+		if r == utf8.RuneError {
+			return fail(ErrorInvalidDateFormat)
+		} else if n != 4 {
+			return fail(ErrorYearIsOutOfRange)
+		}
 	}
 
 	b = strings.TrimLeftFunc(b, isSp)
@@ -512,11 +1246,11 @@ func ParseDate(b string) (int, int, int, string, error) {
 	// 年のサフィックスをデコード
 	r, s = utf8.DecodeRuneInString(b)
 	if r == utf8.RuneError {
-		return year, month, day, "", ErrorInvalidDateFormat
+		return fail(ErrorInvalidDateFormat)
 	}
 	monthsuffix, ok := year2monthSuffix[r]
 	if !ok {
-		return year, month, day, "", ErrorInvalidDateFormat
+		return fail(ErrorInvalidDateFormat)
 	}
 	if r == '年' {
 		daysuffix = '日'
@@ -529,11 +1263,11 @@ func ParseDate(b string) (int, int, int, string, error) {
 
 	b, month, r, n = DecodeDigit(b)
 	if r == utf8.RuneError {
-		return year, month, day, "", ErrorInvalidDateFormat
+		return fail(ErrorInvalidDateFormat)
 	} else if n == 0 {
-		return year, month, day, "", ErrorNoMonthSpecified
+		return fail(ErrorNoMonthSpecified)
 	} else if n > 2 {
-		return year, month, day, "", ErrorMonthIsOutOfRange
+		return fail(ErrorMonthIsOutOfRange)
 	}
 
 	b = strings.TrimLeftFunc(b, isSp)
@@ -541,10 +1275,10 @@ func ParseDate(b string) (int, int, int, string, error) {
 	// 月のサフィックスをデコード
 	r, s = utf8.DecodeRuneInString(b)
 	if r == utf8.RuneError {
-		return year, month, day, "", ErrorInvalidDateFormat
+		return fail(ErrorInvalidDateFormat)
 	}
 	if ms, ok := monthSuffixes[r]; !ok || ms != monthsuffix {
-		return year, month, day, "", ErrorInvalidMonthSuffix
+		return fail(ErrorInvalidMonthSuffix)
 	}
 	b = b[s:]
 
@@ -552,11 +1286,11 @@ func ParseDate(b string) (int, int, int, string, error) {
 
 	b, day, r, n = DecodeDigit(b)
 	if r == utf8.RuneError && len(b) > 0 { // 終端まで逹っしていないのにエラー
-		return year, month, day, "", ErrorInvalidDateFormat
+		return fail(ErrorInvalidDateFormat)
 	} else if n == 0 {
-		return year, month, day, "", ErrorNoDaySpecified
+		return fail(ErrorNoDaySpecified)
 	} else if n > 2 {
-		return year, month, day, "", ErrorDayIsOutOfRange
+		return fail(ErrorDayIsOutOfRange)
 	}
 
 	// 日のサフィックスをデコード(ある場合のみ)
@@ -565,44 +1299,57 @@ func ParseDate(b string) (int, int, int, string, error) {
 
 		r, s = utf8.DecodeRuneInString(b)
 		if r == utf8.RuneError {
-			return year, month, day, "", ErrorInvalidDateFormat
+			return fail(ErrorInvalidDateFormat)
 		}
 		if r != daysuffix {
-			return year, month, day, "", ErrorInvalidDaySuffix
+			return fail(ErrorInvalidDaySuffix)
 		}
 		b = b[s:] // 日のサフィックスを読み飛す
-		r, s = utf8.DecodeRuneInString(b)
 	}
-
-	// 日付けの直後の文字がないか、空白でなければエラー
-	if len(b) == 0 || isSp(r) {
-		return year, month, day, strings.TrimFunc(b, isSp), nil
+	// 元号の日付は、その元号の期間の中になければならない。
+	if era >= 0 && !inEra(era, year, month, day) {
+		return year, month, day, "", begin, len(src) - len(b), ErrorYearIsOutOfRange
 	}
-	return year, month, day, "", ErrorUnknownDateSuffix
+	return year, month, day, b, begin, len(src) - len(b), nil
 }
 
 // ParseLogDate 日付より前の部分と、カッコの中の日付より後ろの部分も返す。
 func ParseLogDate(b string) (int, int, int, string, string, error) {
-	var (
-		year, month, day int
-		i, s             int
-		err              error
-	)
-	i, s = LastIndexFuncWithSize(b, isOpenParenthesis)
+	year, month, day, pre, post, _, _, err := ParseLogDateRange(b)
+	return year, month, day, pre, post, err
+}
+
+// ParseLogDateRange ParseLogDateと同じように日付を解析し、bの中の日付の範囲をバイト数で返す。
+// カッコが見つからない場合は行全体、閉じカッコの後に文字が続く場合はその文字列をエラーの範囲とする。
+func ParseLogDateRange(b string) (int, int, int, string, string, int, int, error) {
+	pre, inner, base, begin, end, err := splitLogDate(b)
+	if err != nil {
+		return 0, 0, 0, pre, "", begin, end, err
+	}
+	year, month, day, post, begin, end, err := ParseDateRange(inner)
+	return year, month, day, pre, post, base + begin, base + end, err
+}
+
+// splitLogDate 最後の行の末尾のカッコの中を取り出す。カッコより前の部分と、bの中のカッコの中の始まりも返す。
+// エラーの場合は、エラーの範囲を返す。
+func splitLogDate(b string) (string, string, int, int, int, error) {
+	end := len(strings.TrimRightFunc(b, isSp))
+	i, s := LastIndexFuncWithSize(b, isOpenParenthesis)
 	if i < 0 {
-		return year, month, day, "", "", ErrorNoOpenParenthesis
+		return "", "", 0, len(b) - len(strings.TrimLeftFunc(b, isSp)), end, ErrorNoOpenParenthesis
 	}
 	pre := b[:i] // 日付けよりも前の部分
-	b = b[i+s:]
+	open := i
+	base := i + s
+	b = b[base:]
 	// 最後の左括弧から一番近い右括弧までの間を日付が入っていると想定してパースする。
 	i, s = IndexFuncWithSize(b, isCloseParenthesis)
 	if i < 0 {
-		return year, month, day, pre, "", ErrorNoCloseParenthesis
+		return pre, "", base, open, end, ErrorNoCloseParenthesis
 	}
 	// 閉じカッコの後に文字が続く場合は、日付けとみなさない。
-	if len(strings.TrimLeftFunc(b[i+s:], isSp)) > 0 {
-		return year, month, day, pre, "", ErrorExtraTextAfterDate
+	if extra := strings.TrimLeftFunc(b[i+s:], isSp); len(extra) > 0 {
+		return pre, "", base, base + i + s + len(b[i+s:]) - len(extra), end, ErrorExtraTextAfterDate
 	}
-	year, month, day, post, err := ParseDate(b[:i])
-	return year, month, day, pre, post, err
+	return pre, b[:i], base, 0, 0, nil
 }
@@ -2,6 +2,9 @@ package dptxt
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -71,8 +74,8 @@ func TestParseRaw(t *testing.T) {
 
 `
 	expected := Document{
-		"test1",
-		map[string]*Section{
+		Filename: "test1",
+		Sections: map[string]*Section{
 			"test": {
 				Value: []*Paragraph{
 					{
@@ -146,7 +149,6 @@ func TestParseRaw(t *testing.T) {
 				peekedValue: "",
 			},
 		},
-		nil,
 	}
 
 	var doc Document
@@ -447,3 +449,510 @@ func TestParseLogDateErr(t *testing.T) {
 		}
 	}
 }
+
+func TestSectionList(t *testing.T) {
+	src := `@title: hello
+@date: 2019/1/2
+@author: bob
+@date: 2019/1/3
+`
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"title", "date", "author", "date"}
+	if len(doc.SectionList) != len(names) {
+		t.Fatal("Document.SectionList", len(doc.SectionList))
+	}
+	for i, n := range names {
+		if doc.SectionList[i].Name != n {
+			t.Error("Document.SectionList", i, doc.SectionList[i].Name, n)
+		}
+	}
+	if doc.Sections["date"] != doc.SectionList[3] {
+		t.Error("Document.Sections", doc.Sections["date"])
+	}
+
+	expected := `test,"title":"hello","date":"2019/1/2","author":"bob","date":"2019/1/3"`
+	if doc.String() != expected {
+		t.Error("Document.String", doc.String())
+	}
+
+	doc.RemoveSection("date")
+	doc.SetSection("author", NewTextSection("alice"))
+	doc.SetSection("memo", NewTextSection("memo"))
+	expected = `test,"title":"hello","author":"alice","memo":"memo"`
+	if doc.String() != expected {
+		t.Error("Document.String", doc.String())
+	}
+}
+
+func TestDuplicateSection(t *testing.T) {
+	src := `@log: a(2019/1/1)
+@title: hello
+@log: b(2019/1/2)
+
+c(2019/1/3)
+`
+	var doc Document
+	err := ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, &ParseOptions{Duplicate: DuplicateLast})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Sections["log"].Value) != 2 || doc.Sections["log"].PeekString() != "b(2019/1/2)" {
+		t.Error("DuplicateLast", doc.Sections["log"])
+	}
+	if !errors.Is(doc.Sections["log"].Error, ErrorDuplicateSection) {
+		t.Error("DuplicateLast", doc.Sections["log"].Error)
+	}
+
+	doc = Document{}
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, &ParseOptions{Duplicate: DuplicateMerge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Sections["log"].Value) != 3 || doc.Sections["log"].PeekString() != "a(2019/1/1)" {
+		t.Error("DuplicateMerge", doc.Sections["log"])
+	}
+	if !errors.Is(doc.Sections["log"].Error, ErrorDuplicateSection) {
+		t.Error("DuplicateMerge", doc.Sections["log"].Error)
+	}
+
+	doc = Document{}
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, &ParseOptions{Duplicate: DuplicateError})
+	var pe *ParseError
+	var de *DuplicateSectionError
+	if !errors.As(err, &pe) || pe.Line != 3 {
+		t.Error("DuplicateError", err)
+	}
+	if !errors.As(err, &de) || de.Linenum != 3 || de.PrevLinenum != 1 || de.Name != "log" {
+		t.Error("DuplicateError", err)
+	}
+	if !errors.Is(err, ErrorDuplicateSection) {
+		t.Error("DuplicateError", err)
+	}
+}
+
+func TestEscapeAt(t *testing.T) {
+	src := `@description: \@head
+連絡先:
+  \@taro
+\＠hanako
+\\@jiro
+@author: bob
+`
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Section{
+		Value: []*Paragraph{
+			{Value: []string{"@head", "連絡先:", "@taro", "＠hanako", `\@jiro`}},
+		},
+	}
+	compareSection(t, doc.Sections["description"], expected)
+	if len(doc.Sections) != 2 {
+		t.Error("Document.Sections", doc.Sections)
+	}
+}
+
+func TestVerbatim(t *testing.T) {
+	src := "@description: 落ちました。\n" +
+		"```text\n" +
+		"panic: runtime error\n" +
+		"\n" +
+		"\tmain.go:12 +0x1d  \n" +
+		"@foo: bar\n" +
+		"```\n" +
+		"続き\n" +
+		"@code: ```\n" +
+		"  x := 1\n" +
+		"   ````\n" +
+		"@author: bob\n"
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := doc.Sections["description"]
+	expected := &Section{
+		Value: []*Paragraph{
+			{Value: []string{"落ちました。"}},
+			{Value: []string{"panic: runtime error", "", "\tmain.go:12 +0x1d  ", "@foo: bar"}},
+			{Value: []string{"続き"}},
+		},
+	}
+	compareSection(t, desc, expected)
+	if len(desc.Value) == 3 {
+		p := desc.Value[1]
+		if p.Kind != VerbatimParagraph || p.Info != "text" || p.Linenum != 3 {
+			t.Error("Paragraph.Kind", p.Kind, p.Info, p.Linenum)
+		}
+		if desc.Value[2].Kind != TextParagraph {
+			t.Error("Paragraph.Kind", desc.Value[2].Kind)
+		}
+	}
+	code := doc.Sections["code"]
+	compareSection(t, code, &Section{Value: []*Paragraph{{Value: []string{"  x := 1"}}}})
+	if len(doc.Sections) != 3 {
+		t.Error("Document.Sections", doc.Sections)
+	}
+
+	err = ParseDocument("test", bytes.NewBufferString("@a: b\n\n```\nabc\n"), &doc)
+	var pe *ParseError
+	if !errors.Is(err, ErrorUnclosedVerbatim) || !errors.As(err, &pe) || pe.Line != 3 {
+		t.Error("ErrorUnclosedVerbatim", err)
+	}
+}
+
+func TestComment(t *testing.T) {
+	src := `// テンプレートの説明
+# 作成者は必ず書くこと
+@author: bob
+@log:
+# 作業内容を書く
+調べた。
+// レビュー時のメモ
+原因が分かった。(2019/1/2)
+ // これはコメントではない
+
+// 途中のコメント
+直した。(2019/1/3)
+@memo: hello
+`
+	opts := &ParseOptions{CommentPrefixes: []string{"#", "//"}}
+	var doc Document
+	err := ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Section{
+		Value: []*Paragraph{
+			{Value: []string{"調べた。", "原因が分かった。(2019/1/2)", "// これはコメントではない"}},
+			{Value: []string{"直した。(2019/1/3)"}},
+		},
+	}
+	log := doc.Sections["log"]
+	compareSection(t, log, expected)
+	if len(log.Value) == 2 {
+		lines := []int{6, 8, 9}
+		for i, l := range lines {
+			if log.Value[0].LineOf(i) != l {
+				t.Error("Paragraph.LineOf", i, log.Value[0].LineOf(i), l)
+			}
+		}
+		if log.Value[1].Linenum != 12 || log.Value[1].LineOf(0) != 12 {
+			t.Error("Paragraph.Linenum", log.Value[1].Linenum)
+		}
+	}
+	if len(doc.Sections) != 3 {
+		t.Error("Document.Sections", doc.Sections)
+	}
+
+	var buf bytes.Buffer
+	if err = WriteDocument(&buf, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != src {
+		t.Errorf("round trip %q", buf.String())
+	}
+
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString("// a\n// b\n@title: a\n// c\n@broken\n"), &doc, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 5 {
+		t.Error("ParseError", err)
+	}
+}
+
+func TestNestedSection(t *testing.T) {
+	src := `@title: hello
+@env: 再現環境
+@@os: Windows 10
+@@browser:
+Edge
+@@@version: 79
+@＠ os　version : 1909
+@author: bob
+`
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Sections) != 3 || len(doc.SectionList) != 3 {
+		t.Fatal("Document.Sections", doc.Sections)
+	}
+	env := doc.Sections["env"]
+	if len(env.ChildList) != 3 || len(env.Children) != 3 {
+		t.Fatal("Section.Children", env.Children)
+	}
+	paths := map[string]string{
+		"env":                   "再現環境",
+		"env/os":                "Windows 10",
+		"env/browser":           "Edge",
+		"env / browser/version": "79",
+		"env/os version":        "1909",
+		"title":                 "hello",
+	}
+	for p, v := range paths {
+		sec := doc.Lookup(p)
+		if sec == nil || sec.PeekString() != v {
+			t.Error("Document.Lookup", p, sec)
+		}
+	}
+	for _, p := range []string{"os", "env/version", "env/", "title/os"} {
+		if doc.Lookup(p) != nil {
+			t.Error("Document.Lookup", p)
+		}
+	}
+
+	expected := `test,"title":"hello","env":"再現環境","env/os":"Windows 10","env/browser":"Edge","env/browser/version":"79","env/os version":"1909","author":"bob"`
+	if doc.String() != expected {
+		t.Error("Document.String", doc.String())
+	}
+
+	err = ParseDocument("test", bytes.NewBufferString("@title: hello\n@@@os: Windows\n"), &doc)
+	var pe *ParseError
+	if !errors.Is(err, ErrorNoParentSection) || !errors.As(err, &pe) || pe.Line != 2 {
+		t.Error("ErrorNoParentSection", err)
+	}
+}
+
+func TestLenient(t *testing.T) {
+	src := `x
+@title: hello
+@author bob
+続き
+@@@os: Windows
+@date: 2019/1/2
+@date: 2019/1/3
+@code:
+` + "```" + `
+abc
+@ : empty
+`
+	opts := &ParseOptions{Lenient: true, Duplicate: DuplicateError}
+	var doc Document
+	err := ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pes ParseErrors
+	if !errors.As(doc.Error, &pes) {
+		t.Fatal("Document.Error", doc.Error)
+	}
+	expected := []struct {
+		line int
+		err  error
+	}{
+		{1, ErrorNoSectionNamePrefix},
+		{3, ErrorNoSectionNameSuffix},
+		{5, ErrorNoParentSection},
+		{7, ErrorDuplicateSection},
+		{9, ErrorUnclosedVerbatim},
+	}
+	if len(pes) != len(expected) {
+		t.Fatal("ParseErrors", pes)
+	}
+	for i, e := range expected {
+		if pes[i].Line != e.line || !errors.Is(pes[i], e.err) {
+			t.Error("ParseErrors", i, pes[i])
+		}
+	}
+	for n, v := range map[string]string{"title": "hello", "os": "Windows", "date": "2019/1/2", "code": "abc"} {
+		if sec := doc.Sections[n]; sec == nil || sec.PeekString() != v {
+			t.Error("Document.Sections", n, sec)
+		}
+	}
+	if len(doc.Sections) != 4 {
+		t.Error("Document.Sections", doc.Sections)
+	}
+	if doc.Sections["code"].Value[0].Error == nil {
+		t.Error("Paragraph.Error", doc.Sections["code"].Value[0])
+	}
+
+	var buf bytes.Buffer
+	if err = WriteDocument(&buf, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != src {
+		t.Errorf("round trip %q", buf.String())
+	}
+
+	// 寛容モードでなければ最初のエラーで止まる。
+	opts.Lenient = false
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+	if !errors.Is(err, ErrorNoSectionNamePrefix) {
+		t.Error(err)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	src := "\n@title:　題名\n  \\@at 本文\n  ２行目\n\n```go\nx\n```\n@log: 作業(2017-13-40)\n"
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	title := doc.Sections["title"]
+	tests := []struct {
+		name     string
+		actual   Span
+		expected Span
+	}{
+		{"Section.Span", title.Span, Span{Position{2, 1, 1, 1}, Position{8, 4, 4, 56}}},
+		{"Section.NameSpan", title.NameSpan, Span{Position{2, 2, 2, 2}, Position{2, 7, 7, 7}}},
+		{"Paragraph.Span", title.Value[0].Span, Span{Position{2, 9, 11, 11}, Position{4, 6, 12, 43}}},
+		{"Paragraph.Span verbatim", title.Value[1].Span, Span{Position{6, 1, 1, 45}, Position{8, 4, 4, 56}}},
+		{"Paragraph.SpanOf", title.Value[0].SpanOf(1, 0, 1), Span{Position{3, 4, 4, 21}, Position{3, 5, 5, 22}}},
+	}
+	for _, tt := range tests {
+		if tt.actual != tt.expected {
+			t.Errorf("%s\nexpected: %v\nactual:   %v", tt.name, tt.expected, tt.actual)
+		}
+	}
+	if src[title.Span.Start.Offset:title.Span.End.Offset] != "@title:　題名\n  \\@at 本文\n  ２行目\n\n```go\nx\n```" {
+		t.Error("Section.Span", title.Span)
+	}
+
+	// ログの日付の範囲
+	p := doc.Sections["log"].Value[0]
+	_, month, _, _, _, begin, end, err := ParseLogDateRange(p.Value[0])
+	if err != nil || month != 13 {
+		t.Fatal(err, month)
+	}
+	span := p.SpanOf(0, begin, end)
+	if src[span.Start.Offset:span.End.Offset] != "2017-13-40" || span.Start != (Position{9, 10, 14, 70}) {
+		t.Error("ParseLogDateRange", span)
+	}
+
+	// 変更されたパラグラフの位置は分からない。
+	p.Value[0] = "作業"
+	if p.PositionOf(0, 0).IsValid() {
+		t.Error("PositionOf", p.PositionOf(0, 0))
+	}
+}
+
+func TestParseErrorSpan(t *testing.T) {
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString("@title: a\n  @author\n"), &doc)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatal(err)
+	}
+	if pe.Span != (Span{Position{2, 3, 3, 12}, Position{2, 10, 10, 19}}) {
+		t.Error(pe.Span)
+	}
+	// エラーメッセージの書式は変わらない。
+	if pe.Error() != "test:2: "+ErrorNoSectionNameSuffix.Error() {
+		t.Error(pe.Error())
+	}
+}
+
+func TestParseLogDateRange(t *testing.T) {
+	tests := []struct {
+		src        string
+		begin, end int
+		err        error
+	}{
+		{"作業 ( 2019/1/2 ) ", 9, 17, nil},
+		{" abc ", 1, 4, ErrorNoOpenParenthesis},
+		{"a (2019/1/2", 2, 11, ErrorNoCloseParenthesis},
+		{"a (2019/1/2) x", 13, 14, ErrorExtraTextAfterDate},
+		{"(2019/1/x)", 1, 9, ErrorNoDaySpecified},
+		{"(2019年1月2)", 1, 13, ErrorInvalidDateFormat},
+	}
+	for _, tt := range tests {
+		_, _, _, _, _, begin, end, err := ParseLogDateRange(tt.src)
+		if begin != tt.begin || end != tt.end || err != tt.err {
+			t.Errorf("%q: %d, %d, %v", tt.src, begin, end, err)
+		}
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	long := strings.Repeat("あ", 100000)
+	src := "@title: a\n@memo: " + long + "\n@log: b\n"
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Sections["memo"].PeekString() != long || doc.Sections["log"].Linenum != 3 {
+		t.Error("Document.Sections", doc.Sections["log"])
+	}
+
+	opts := &ParseOptions{MaxLineLength: 1000}
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 2 || !errors.Is(err, ErrorLineTooLong) {
+		t.Error(err)
+	}
+
+	// 寛容モードでは長すぎる行の手前までを読み込む。
+	opts.Lenient = true
+	err = ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+	if err != nil || doc.Sections["title"] == nil {
+		t.Fatal(err, doc.Sections)
+	}
+	var pes ParseErrors
+	if !errors.As(doc.Error, &pes) || len(pes) != 1 || pes[0].Line != 2 || !errors.Is(pes[0], ErrorLineTooLong) {
+		t.Error("Document.Error", doc.Error)
+	}
+}
+
+// countingReader 読み込んだバイト数を数える。
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestLongLineAfterCR(t *testing.T) {
+	long := strings.Repeat("x", 1<<22)
+	src := "@title: a\r@memo: b\r" + long + "\n@log: c\n"
+	opts := &ParseOptions{MaxLineLength: 1000}
+	cr := &countingReader{r: strings.NewReader(src)}
+	var doc Document
+	err := ParseDocumentWithOptions("test", cr, &doc, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 3 || !errors.Is(err, ErrorLineTooLong) {
+		t.Error(err)
+	}
+	// 長すぎる行を最後まで読み込まずにやめる。
+	if cr.n > 1<<16 {
+		t.Error("read", cr.n)
+	}
+
+	opts.Lenient = true
+	err = ParseDocumentWithOptions("test", strings.NewReader(src), &doc, opts)
+	if err != nil || doc.Sections["title"].PeekString() != "a" || doc.Sections["memo"].PeekString() != "b" {
+		t.Fatal(err, doc.Sections)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	src := "@title: a\r\n@memo: b\rc\r\rd\n@log: e"
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memo := doc.Sections["memo"]
+	if len(memo.Value) != 2 || memo.Value[0].String() != `b\nc` || memo.Value[1].LineOf(0) != 5 {
+		t.Error("memo", memo)
+	}
+	if log := doc.Sections["log"]; log.Linenum != 6 || log.PeekString() != "e" || log.Span.Start.Offset != len(src)-len("@log: e") {
+		t.Error("log", log)
+	}
+	if actual := writeString(t, &doc); actual != src {
+		t.Errorf("round trip %q", actual)
+	}
+}
@@ -0,0 +1,40 @@
+package dptxt
+
+import (
+	"unicode/utf8"
+)
+
+// Position 入力の中の位置
+type Position struct {
+	Line       int // 1から始まる行番号
+	Column     int // 1から始まる、行の中のルーン単位の位置
+	ByteColumn int // 1から始まる、行の中のバイト単位の位置
+	Offset     int // 0から始まる、入力の先頭からのバイト単位の位置
+}
+
+// Span 入力の中の範囲。Endは範囲の最後の文字の直後の位置になる。
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// IsValid 入力の中の位置を表わしているかどうか。
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// Advance 同じ行の中でsの分だけ後ろの位置を返す。
+func (p Position) Advance(s string) Position {
+	if !p.IsValid() {
+		return p
+	}
+	p.Column += utf8.RuneCountInString(s)
+	p.ByteColumn += len(s)
+	p.Offset += len(s)
+	return p
+}
+
+// lineSpan 同じ行の中で、startから始まるsの範囲を返す。
+func lineSpan(start Position, s string) Span {
+	return Span{start, start.Advance(s)}
+}
@@ -0,0 +1,236 @@
+package dptxt
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 基準日からの日数で表わす言葉。長いものから順に比べる。
+var relativeDays = []struct {
+	word string
+	days int
+}{
+	{"明後日", 2},
+	{"明日", 1},
+	{"今日", 0},
+	{"本日", 0},
+	{"昨日", -1},
+}
+
+// 週の前に付ける言葉と、基準日の週からの週数
+var relativeWeeks = []struct {
+	word  string
+	weeks int
+}{
+	{"再来週", 2},
+	{"来週", 1},
+	{"今週", 0},
+	{"先週", -1},
+}
+
+// 月末を表わす言葉と、基準日の月からの月数
+var relativeMonthEnds = []struct {
+	word   string
+	months int
+}{
+	{"再来月末", 2},
+	{"来月末", 1},
+	{"今月末", 0},
+}
+
+// 月曜日を0とする曜日
+var weekdays = []rune("月火水木金土日")
+
+// 「+2w」「3日後」の単位と、単位一つ分の日数または月数。アルファベットの単位は「+2w」の形式でだけ使える。
+var relativeUnits = []struct {
+	unit  string
+	days  int
+	month int
+}{
+	{"d", 1, 0},
+	{"w", 7, 0},
+	{"m", 0, 1},
+	{"y", 0, 12},
+	{"週間", 7, 0},
+	{"日", 1, 0},
+	{"週", 7, 0},
+	{"か月", 0, 1},
+	{"ヶ月", 0, 1},
+	{"ヵ月", 0, 1},
+	{"カ月", 0, 1},
+	{"ケ月", 0, 1},
+	{"箇月", 0, 1},
+	{"年", 0, 12},
+}
+
+// addMonths tのn箇月後の日付を返す。
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	// 移動先の月にない日は月末にする。
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return time.Date(first.Year(), first.Month(), d, 0, 0, 0, 0, t.Location())
+}
+
+// weekdayIndex 月曜日を0とする曜日の番号
+func weekdayIndex(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// parseRelativeDay 「明日」「明後日」の形式を解析する。
+func parseRelativeDay(b string, base time.Time) (time.Time, string, bool) {
+	for _, w := range relativeDays {
+		if strings.HasPrefix(b, w.word) {
+			return base.AddDate(0, 0, w.days), b[len(w.word):], true
+		}
+	}
+	return base, b, false
+}
+
+// parseRelativeOffset 「+2w」「-3d」「3日後」「1か月前」の形式を解析する。
+func parseRelativeOffset(b string, base time.Time) (time.Time, string, bool) {
+	sign := 0
+	r, s := utf8.DecodeRuneInString(b)
+	switch r {
+	case '+', '＋':
+		sign = 1
+		b = b[s:]
+	case '-', '－':
+		sign = -1
+		b = b[s:]
+	}
+	rest, n, _, digits := DecodeDigit(b)
+	if digits == 0 {
+		return base, b, false
+	}
+	for _, u := range relativeUnits {
+		unit := rest
+		if len(u.unit) == 1 {
+			if sign == 0 {
+				continue
+			}
+			// アルファベットの単位は大文字でもよい。
+			unit = strings.ToLower(rest)
+		}
+		if !strings.HasPrefix(unit, u.unit) {
+			continue
+		}
+		rest = rest[len(u.unit):]
+		if sign == 0 {
+			// 「後」か「前」が必要。
+			switch {
+			case strings.HasPrefix(rest, "後"):
+				sign = 1
+			case strings.HasPrefix(rest, "前"):
+				sign = -1
+			default:
+				return base, rest, false
+			}
+			rest = rest[len("後"):]
+		}
+		if u.month != 0 {
+			return addMonths(base, sign*n*u.month), rest, true
+		}
+		return base.AddDate(0, 0, sign*n*u.days), rest, true
+	}
+	return base, rest, false
+}
+
+// parseRelativeWeekday 「来週金曜」「来週の金曜日」「金曜」の形式を解析する。
+// 週を指定しない場合は基準日以降で最初のその曜日になる。週は月曜日から始まる。
+func parseRelativeWeekday(b string, base time.Time) (time.Time, string, bool) {
+	weeks, hasWeek := 0, false
+	for _, w := range relativeWeeks {
+		if strings.HasPrefix(b, w.word) {
+			weeks, hasWeek = w.weeks, true
+			b = strings.TrimPrefix(b[len(w.word):], "の")
+			break
+		}
+	}
+	r, s := utf8.DecodeRuneInString(b)
+	wd := -1
+	for i, w := range weekdays {
+		if r == w {
+			wd = i
+		}
+	}
+	if wd < 0 {
+		return base, b, false
+	}
+	rest := b[s:]
+	// 「金」だけでは曜日とみなさない。
+	if !strings.HasPrefix(rest, "曜") {
+		return base, b, false
+	}
+	rest = strings.TrimPrefix(rest[len("曜"):], "日")
+	if hasWeek {
+		return base.AddDate(0, 0, weeks*7+wd-weekdayIndex(base)), rest, true
+	}
+	return base.AddDate(0, 0, (wd-weekdayIndex(base)+7)%7), rest, true
+}
+
+// parseRelativeMonthEnd 「今月末」「来月末」の形式を解析する。
+func parseRelativeMonthEnd(b string, base time.Time) (time.Time, string, bool) {
+	for _, m := range relativeMonthEnds {
+		if strings.HasPrefix(b, m.word) {
+			y, mon, _ := base.Date()
+			t := time.Date(y, mon+time.Month(m.months)+1, 0, 0, 0, 0, 0, base.Location())
+			return t, b[len(m.word):], true
+		}
+	}
+	return base, b, false
+}
+
+var relativeParsers = []func(string, time.Time) (time.Time, string, bool){
+	parseRelativeDay,
+	parseRelativeOffset,
+	parseRelativeWeekday,
+	parseRelativeMonthEnd,
+}
+
+// ParseRelativeDate 「+2w」「3日後」「明日」「来週金曜」「来月末」のような、baseからの相対的な日付を解析する。
+// 日付の後ろには空白を空けて時刻を書ける。相対的な日付でなければErrorNotRelativeDateを返す。
+// 日付より後ろの部分も返す。
+func ParseRelativeDate(b string, base time.Time) (DateTime, string, error) {
+	var dt DateTime
+	b = strings.TrimFunc(b, isSp)
+	y, m, d := base.Date()
+	base = time.Date(y, m, d, 0, 0, 0, 0, base.Location())
+
+	var (
+		t    time.Time
+		rest string
+		ok   bool
+	)
+	for _, parse := range relativeParsers {
+		if t, rest, ok = parse(b, base); ok {
+			break
+		}
+	}
+	if !ok {
+		return dt, "", ErrorNotRelativeDate
+	}
+	y, m, d = t.Date()
+	dt.Year, dt.Month, dt.Day = y, int(m), d
+
+	r, _ := utf8.DecodeRuneInString(rest)
+	if len(rest) == 0 {
+		return dt, "", nil
+	} else if !isSp(r) {
+		return dt, "", ErrorNotRelativeDate
+	}
+	rest = strings.TrimLeftFunc(rest, isSp)
+	c, ok, err := parseClock(rest, &dt)
+	if err != nil {
+		return dt, "", err
+	} else if !ok {
+		return dt, rest, nil
+	}
+	if r, _ := utf8.DecodeRuneInString(c); len(c) > 0 && !isSp(r) {
+		return dt, "", ErrorInvalidTimeFormat
+	}
+	return dt, strings.TrimFunc(c, isSp), nil
+}
@@ -0,0 +1,55 @@
+package dptxt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRelativeDate(t *testing.T) {
+	// 2024-01-31は水曜日
+	base := time.Date(2024, 1, 31, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		src      string
+		expected DateTime
+		post     string
+	}{
+		{"今日", DateTime{Year: 2024, Month: 1, Day: 31}, ""},
+		{"明後日", DateTime{Year: 2024, Month: 2, Day: 2}, ""},
+		{"+3d", DateTime{Year: 2024, Month: 2, Day: 3}, ""},
+		{"+2W", DateTime{Year: 2024, Month: 2, Day: 14}, ""},
+		{"-1d", DateTime{Year: 2024, Month: 1, Day: 30}, ""},
+		{"+1m", DateTime{Year: 2024, Month: 2, Day: 29}, ""},
+		{"+1y", DateTime{Year: 2025, Month: 1, Day: 31}, ""},
+		{"＋３日", DateTime{Year: 2024, Month: 2, Day: 3}, ""},
+		{"3日後", DateTime{Year: 2024, Month: 2, Day: 3}, ""},
+		{"2週間後", DateTime{Year: 2024, Month: 2, Day: 14}, ""},
+		{"1ヶ月前", DateTime{Year: 2023, Month: 12, Day: 31}, ""},
+		{"金曜", DateTime{Year: 2024, Month: 2, Day: 2}, ""},
+		{"水曜日", DateTime{Year: 2024, Month: 1, Day: 31}, ""},
+		{"今週月曜", DateTime{Year: 2024, Month: 1, Day: 29}, ""},
+		{"来週金曜", DateTime{Year: 2024, Month: 2, Day: 9}, ""},
+		{"来週の月曜日", DateTime{Year: 2024, Month: 2, Day: 5}, ""},
+		{"再来週日曜", DateTime{Year: 2024, Month: 2, Day: 18}, ""},
+		{"来月末", DateTime{Year: 2024, Month: 2, Day: 29}, ""},
+		{"明日 17:00", DateTime{2024, 2, 1, 17, 0, 0, true, false, 0}, ""},
+		{"明日 午後", DateTime{Year: 2024, Month: 2, Day: 1}, "午後"},
+	}
+	for _, tt := range tests {
+		dt, post, err := ParseRelativeDate(tt.src, base)
+		if err != nil || dt != tt.expected || post != tt.post {
+			t.Errorf("%q: %+v, %q, %v", tt.src, dt, post, err)
+		}
+	}
+}
+
+func TestParseRelativeDateErr(t *testing.T) {
+	base := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, src := range []string{"", "2024-02-01", "3d後", "3日", "+3", "+3x", "金", "来週", "明日x"} {
+		if dt, _, err := ParseRelativeDate(src, base); err != ErrorNotRelativeDate {
+			t.Errorf("%q: %+v, %v", src, dt, err)
+		}
+	}
+	if _, _, err := ParseRelativeDate("明日 17:0", base); err != ErrorInvalidTimeFormat {
+		t.Error(err)
+	}
+}
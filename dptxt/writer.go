@@ -0,0 +1,209 @@
+package dptxt
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type docWriter struct {
+	w       *bufio.Writer
+	eol     string
+	bol     bool // 行頭にいるか
+	dialect *Dialect
+}
+
+// writeRaw 読み込んだときの行をそのまま書き出す。
+func (dw *docWriter) writeRaw(lines []string) error {
+	for _, l := range lines {
+		if err := dw.writeString(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dw *docWriter) writeString(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := dw.w.WriteString(s)
+	if err != nil {
+		return err
+	}
+	last := s[len(s)-1]
+	dw.bol = last == '\n' || last == '\r'
+	return nil
+}
+
+// newLine 行頭にいなければ改行する。
+func (dw *docWriter) newLine() error {
+	if dw.bol {
+		return nil
+	}
+	return dw.writeString(dw.eol)
+}
+
+// writeLines 値を一行ずつ書き出す。セクション名の行と間違えないように行頭の@などはエスケープする。
+func (dw *docWriter) writeLines(lines []string) error {
+	for _, l := range lines {
+		if err := dw.writeString(dw.dialect.escape(l) + dw.eol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Paragraph) modified() bool {
+	if p.raw == nil || len(p.Value) != len(p.orig) {
+		return true
+	}
+	for i := range p.Value {
+		if p.Value[i] != p.orig[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// fenceFor linesを囲むのに十分な長さの```を返す。
+func fenceFor(lines []string) string {
+	fence := "```"
+	for _, l := range lines {
+		if closeFence(l, fence) {
+			fence = strings.Repeat("`", len(strings.TrimFunc(l, isSp))+1)
+		}
+	}
+	return fence
+}
+
+func (dw *docWriter) writeParagraph(p *Paragraph) error {
+	if !p.modified() {
+		return dw.writeRaw(p.raw)
+	}
+	if p.Kind == VerbatimParagraph {
+		fence := fenceFor(p.Value)
+		err := dw.writeString(fence + p.Info + dw.eol)
+		if err != nil {
+			return err
+		}
+		for _, l := range p.Value {
+			if err = dw.writeString(l + dw.eol); err != nil {
+				return err
+			}
+		}
+		return dw.writeString(fence + dw.eol)
+	}
+	return dw.writeLines(p.Value)
+}
+
+func (dw *docWriter) writeSection(name string, sec *Section, depth int) error {
+	err := dw.newLine()
+	if err != nil {
+		return err
+	}
+	if err = dw.writeRaw(sec.pre); err != nil {
+		return err
+	}
+
+	// セクション名が変更されていなければ、読み込んだときの行をそのまま使う。
+	inline := sec.inline
+	sp := empty
+	if len(sec.header) > 0 && sec.name == name {
+		err = dw.writeString(sec.header)
+	} else {
+		inline = true
+		sp = " "
+		err = dw.writeString(dw.dialect.header(name, depth))
+	}
+	if err != nil {
+		return err
+	}
+
+	first := true
+	prev := TextParagraph
+	for _, p := range sec.Value {
+		if len(p.Value) == 0 && p.Kind == TextParagraph {
+			continue
+		}
+		if first && inline {
+			// 同じ行に続けて書く。
+			if err = dw.writeString(sp); err != nil {
+				return err
+			}
+		} else if len(p.pre) > 0 {
+			if err = dw.newLine(); err != nil {
+				return err
+			}
+			if err = dw.writeRaw(p.pre); err != nil {
+				return err
+			}
+		} else {
+			if err = dw.newLine(); err != nil {
+				return err
+			}
+			// 追加されたパラグラフと、続けると一つのパラグラフになってしまう場合は空白行で区切る。
+			if !first && (p.raw == nil || (p.Kind == TextParagraph && prev == TextParagraph)) {
+				if err = dw.writeString(dw.eol); err != nil {
+					return err
+				}
+			}
+		}
+		if err = dw.writeParagraph(p); err != nil {
+			return err
+		}
+		first = false
+		prev = p.Kind
+	}
+	// 最後の行が改行で終わっていないのは、読み込んだときにそうなっていた場合だけ。
+	if first || len(sec.trail) > 0 {
+		if err = dw.newLine(); err != nil {
+			return err
+		}
+	}
+	if err = dw.writeRaw(sec.trail); err != nil {
+		return err
+	}
+
+	for _, c := range sec.orderedChildren() {
+		if err = dw.writeSection(c.Name, c.Section, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDocument docをdptxt形式でwに書き出す。セクション名の行は読み込んだときの書き方で書き出す。
+// セクションは文書に現われた順に書き出す。ParseDocumentで読み込んだままの文書は、
+// 読み込んだときと同じバイト列になる。変更されたセクションやパラグラフだけが書き直される。
+func WriteDocument(w io.Writer, doc *Document) error {
+	eol := doc.eol
+	if len(eol) == 0 {
+		eol = "\n"
+	}
+	dialect := doc.dialect
+	if dialect == nil {
+		dialect = &DefaultDialect
+	}
+	dw := &docWriter{bufio.NewWriter(w), eol, true, dialect}
+
+	err := dw.writeRaw(doc.lead)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range doc.orderedSections() {
+		if err = dw.writeSection(s.Name, s.Section, 1); err != nil {
+			return err
+		}
+	}
+	if len(doc.tail) > 0 {
+		if err = dw.newLine(); err != nil {
+			return err
+		}
+		if err = dw.writeRaw(doc.tail); err != nil {
+			return err
+		}
+	}
+	return dw.w.Flush()
+}
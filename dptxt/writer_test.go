@@ -0,0 +1,167 @@
+package dptxt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func roundTrip(t *testing.T, src string) *Document {
+	var doc Document
+	err := ParseDocument("test", bytes.NewBufferString(src), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = WriteDocument(&buf, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != src {
+		t.Errorf("round trip\nexpected: %q\nactual:   %q", src, buf.String())
+	}
+	return &doc
+}
+
+func TestWriteDocumentRoundTrip(t *testing.T) {
+	srcs := []string{
+		"",
+		"\n\n",
+		"@title: hello",
+		"@title: hello\n",
+		"\n  \n@title: hello  \n\n\n",
+		"＠作者：ボブ\n@  compile 　	 option　  : -O2  \n\n@author:    \n\n",
+		"@description: ほんじつは、\n  おひがらもよく、\n云々。。。\n\n\tあれこれ\nこれそれ\n\n＠log：\n\nabc(2019/1/2)\n\n\ndef(2019/1/3)",
+		"@title: hello\r\n@log:\r\nabc(2019/1/2)\r\n\r\ndef(2019/1/3)\r\n",
+	}
+	for _, src := range srcs {
+		roundTrip(t, src)
+	}
+}
+
+func writeString(t *testing.T, doc *Document) string {
+	var buf bytes.Buffer
+	err := WriteDocument(&buf, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteDocumentModified(t *testing.T) {
+	doc := roundTrip(t, "＠status： open  \n@log:\nabc(2019/1/2)\n\ndef(2019/1/3)\n\n@memo: hello")
+
+	doc.Sections["status"].Value[0].Value[0] = "closed"
+	log := doc.Sections["log"]
+	log.Value = append(log.Value, NewTextParagraph("ghi(2019/1/4)"))
+	doc.Sections["new"] = NewTextSection("world")
+
+	expected := "＠status： closed\n@log:\nabc(2019/1/2)\n\ndef(2019/1/3)\n\nghi(2019/1/4)\n\n@memo: hello\n@new: world\n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentCRLF(t *testing.T) {
+	doc := roundTrip(t, "@title: hello\r\n@memo:\r\n")
+	doc.Sections["memo"].Value = append(doc.Sections["memo"].Value, NewTextParagraph("world"))
+
+	expected := "@title: hello\r\n@memo:\r\nworld\r\n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentDuplicate(t *testing.T) {
+	doc := roundTrip(t, "@log: a\n@title: hello\n@log: b\n")
+
+	doc.Sections["log"] = NewTextSection("c")
+	expected := "@title: hello\n@log: c\n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentEscape(t *testing.T) {
+	doc := roundTrip(t, "@memo: \\@a\n\\\\@b\n")
+	doc.Sections["memo"].Value[0].Value = append(doc.Sections["memo"].Value[0].Value, "@c", `\@d`, "e@f")
+
+	expected := "@memo: \\@a\n\\\\@b\n\\@c\n\\\\@d\ne@f\n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentVerbatim(t *testing.T) {
+	doc := roundTrip(t, "@code: ```go\n  x := 1\n\n```\n\n@memo:\n~~~\n  ```\n   ```   \n")
+	p := doc.Sections["code"].Value[0]
+	p.Value = append(p.Value, "```")
+
+	expected := "@code: ````go\n  x := 1\n\n```\n````\n\n@memo:\n~~~\n  ```\n   ```   \n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentNested(t *testing.T) {
+	doc := roundTrip(t, "@env:\n@@os: Windows\n\n@@browser: Edge\n@@@version: 79\n@author: bob\n")
+	env := doc.Sections["env"]
+	env.Children["os"].Value[0].Value[0] = "Linux"
+	env.Children["browser"].Children["engine"] = NewTextSection("Blink")
+
+	expected := "@env:\n@@os: Linux\n\n@@browser: Edge\n@@@version: 79\n@@@engine: Blink\n@author: bob\n"
+	actual := writeString(t, doc)
+	if actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
+
+func TestWriteDocumentMerged(t *testing.T) {
+	opts := &ParseOptions{Duplicate: DuplicateMerge}
+	parse := func(src string) *Document {
+		var doc Document
+		err := ParseDocumentWithOptions("test", bytes.NewBufferString(src), &doc, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &doc
+	}
+	srcs := []string{
+		"@log: a\n@title: hello\n@log: b\n\nc\n",
+		"@log: a\n@log: b\n@memo: x\n@log:\nc\n",
+		"@env:\n@@os: Windows\n@author: bob\n@env:\n@@os: Linux\n@@browser: Edge\n",
+	}
+	for _, src := range srcs {
+		if actual := writeString(t, parse(src)); actual != src {
+			t.Errorf("round trip\nexpected: %q\nactual:   %q", src, actual)
+		}
+	}
+
+	// パラグラフの変更は結合する前のセクションの位置に書き戻す。
+	doc := parse(srcs[0])
+	doc.Sections["log"].Value[1].Value[0] = "B"
+	expected := "@log: a\n@title: hello\n@log: B\n\nc\n"
+	if actual := writeString(t, doc); actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+
+	// パラグラフを追加した場合は、結合したセクションを最後のセクションの位置に書き出す。
+	log := doc.Sections["log"]
+	log.Value = append(log.Value, NewTextParagraph("d"))
+	expected = "@title: hello\n@log: a\n\nB\n\nc\n\nd\n"
+	if actual := writeString(t, doc); actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+
+	// 子セクションを置き換えた場合も同じ。
+	doc = parse(srcs[2])
+	doc.Sections["env"].Children["browser"] = NewTextSection("Firefox")
+	expected = "@author: bob\n@env:\n@@os: Windows\n@@os: Linux\n@@browser: Firefox\n"
+	if actual := writeString(t, doc); actual != expected {
+		t.Errorf("expected: %q\nactual:   %q", expected, actual)
+	}
+}
@@ -18,7 +18,9 @@ func main() {
 	flag.Usage = Usage
 
 	var configpath string
+	var resolve bool
 	flag.StringVar(&configpath, "c", "config.json", "config file path")
+	flag.BoolVar(&resolve, "resolve", false, "rewrite relative deadlines in source files to absolute dates")
 	flag.Parse()
 
 	configname, err := filepath.Abs(configpath)
@@ -34,9 +36,17 @@ func main() {
 
 	basepath := filepath.Dir(configname)
 
-	docs := dpsh.LoadAllFiles(basepath, config.SrcPath)
+	if resolve {
+		err = dpsh.ResolveRelativeDates(basepath, &config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	docs := dpsh.LoadAllFilesWithConfig(basepath, &config)
 
 	dpsh.PreprocessAllDocs(&config, docs)
+	docs = dpsh.FilterDocs(&config, docs)
 	dpsh.SortDocs(&config, docs)
 
 	err = dpsh.WriteCsv(basepath, &config, docs)